@@ -1,14 +1,22 @@
 module github.com/olivercullimore/geo-energy-data
 
-go 1.14
+go 1.20
 
 require (
-	github.com/deepmap/oapi-codegen v1.5.0 // indirect
+	github.com/eclipse/paho.mqtt.golang v1.3.5
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/influxdata/influxdb-client-go/v2 v2.2.2
-	github.com/influxdata/line-protocol v0.0.0-20201012155213-5f565037cbc9 // indirect
 	github.com/olivercullimore/geo-energy-data-client v1.1.1
 	github.com/olivercullimore/go-utils/configfile v0.0.0-20210202174944-575562b5d86d
 	github.com/olivercullimore/go-utils/env v0.0.0-20210206205206-0436a866ce48
+)
+
+require (
+	github.com/deepmap/oapi-codegen v1.5.0 // indirect
+	github.com/influxdata/line-protocol v0.0.0-20201012155213-5f565037cbc9 // indirect
+	github.com/joho/godotenv v1.3.0 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/net v0.0.0-20210119194325-5f4716e94777 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"github.com/olivercullimore/geo-energy-data/server"
+	"os"
 )
 
 var (
@@ -19,5 +20,15 @@ func main() {
 	fmt.Println("")
 	fmt.Println(BuildVersion)
 	fmt.Println("----------------------------------------------------------------")
+	// Run backfill command if requested
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		server.Backfill(os.Args[2:])
+		return
+	}
+	// Run schema migration command if requested
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		server.Migrate(os.Args[2:])
+		return
+	}
 	server.Run()
 }
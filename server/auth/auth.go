@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// ScopeReadLive, ScopeReadHistory and ScopeAdmin are the scopes a key can have. Admin keys
+	// have every scope.
+	ScopeReadLive    = "read-live"
+	ScopeReadHistory = "read-history"
+	ScopeAdmin       = "admin"
+
+	// keyPrefix is the prefix of generated keys, so they're easy to recognise
+	keyPrefix = "ged_"
+	// lastUsedInterval is how often the last used times of keys are saved, if they've changed
+	lastUsedInterval = time.Minute
+)
+
+var (
+	// ErrKeyExists is returned when creating a key with the name of an existing key.
+	ErrKeyExists = errors.New("a key with this name already exists")
+	// ErrKeyNotFound is returned when rotating or revoking a key that doesn't exist.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrKeyStatic is returned when rotating or revoking a key set by an environment variable.
+	ErrKeyStatic = errors.New("key is set by an environment variable")
+	// ErrInvalidKey is returned when a key has no name or an invalid scope.
+	ErrInvalidKey = errors.New("key must have a name and scopes of read-live, read-history or admin")
+)
+
+// Key is a named API key as saved in the config file. Only the SHA-256 hash of the key is kept,
+// which is enough for randomly generated keys.
+type Key struct {
+	Name      string
+	Hash      string
+	Scopes    []string
+	CreatedAt time.Time
+	LastUsed  *time.Time `json:",omitempty"`
+	static    bool
+}
+
+// HasScope reports whether the key has scope.
+func (k Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyInfo describes a key without its hash.
+type KeyInfo struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"createdAt"`
+	LastUsed  *time.Time `json:"lastUsed,omitempty"`
+	Static    bool       `json:"static,omitempty"`
+}
+
+// Store holds the API keys, saving changes with a save function. Changes to keys are saved
+// immediately, while the last used times of keys are saved in the background. It is safe for
+// concurrent use.
+type Store struct {
+	mu     sync.Mutex
+	keys   []Key
+	save   func(keys []Key) error
+	logger *log.Logger
+	// dirty is set when last used times have changed since the keys were saved
+	dirty bool
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewStore returns a Store of keys which calls save with the keys to persist whenever they change,
+// logging failures to save last used times with logger. Close must be called to stop it.
+func NewStore(keys []Key, save func(keys []Key) error, logger *log.Logger) *Store {
+	s := &Store{keys: append([]Key(nil), keys...), save: save, logger: logger, done: make(chan struct{})}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// AddStatic adds a key that is set elsewhere, such as by an environment variable, and so can't be
+// rotated or revoked and isn't saved.
+func (s *Store) AddStatic(name, key string, scopes []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, Key{Name: name, Hash: hash(key), Scopes: scopes, CreatedAt: time.Now().UTC(), static: true})
+}
+
+// Authenticate returns the key matching key. Every key is compared in constant time so the time
+// taken doesn't reveal anything about the keys.
+func (s *Store) Authenticate(key string) (Key, bool) {
+	sum := sha256.Sum256([]byte(key))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	match := -1
+	for i, k := range s.keys {
+		stored, err := hex.DecodeString(k.Hash)
+		if err == nil && subtle.ConstantTimeCompare(sum[:], stored) == 1 {
+			match = i
+		}
+	}
+	if match < 0 {
+		return Key{}, false
+	}
+
+	// Record last use, leaving it to be saved in the background
+	k := &s.keys[match]
+	now := time.Now().UTC()
+	k.LastUsed = &now
+	if !k.static {
+		s.dirty = true
+	}
+	return *k, true
+}
+
+// Close stops saving last used times in the background, saving any that have changed.
+func (s *Store) Close() {
+	close(s.done)
+	s.wg.Wait()
+	s.flush()
+}
+
+// run saves changed last used times every lastUsedInterval until the store is closed.
+func (s *Store) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(lastUsedInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// flush saves the keys if last used times have changed since they were saved, logging any error
+// and trying again next time.
+func (s *Store) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return
+	}
+	err := s.persist()
+	if err != nil {
+		s.logger.Printf("Unable to save API key last used times: %s\n", err)
+	}
+}
+
+// Keys returns every key, sorted by name.
+func (s *Store) Keys() []KeyInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]KeyInfo, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, info(k))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Name < keys[j].Name
+	})
+	return keys
+}
+
+// Create generates and saves a new key, returning it and its details.
+func (s *Store) Create(name string, scopes []string) (string, KeyInfo, error) {
+	if name == "" || !validScopes(scopes) {
+		return "", KeyInfo{}, ErrInvalidKey
+	}
+	key, err := generate()
+	if err != nil {
+		return "", KeyInfo{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.find(name) >= 0 {
+		return "", KeyInfo{}, ErrKeyExists
+	}
+	k := Key{Name: name, Hash: hash(key), Scopes: scopes, CreatedAt: time.Now().UTC()}
+	s.keys = append(s.keys, k)
+	err = s.persist()
+	if err != nil {
+		s.keys = s.keys[:len(s.keys)-1]
+		return "", KeyInfo{}, err
+	}
+	return key, info(k), nil
+}
+
+// Rotate replaces a key with a newly generated one with the same name and scopes, returning it.
+func (s *Store) Rotate(name string) (string, KeyInfo, error) {
+	key, err := generate()
+	if err != nil {
+		return "", KeyInfo{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(name)
+	if i < 0 {
+		return "", KeyInfo{}, ErrKeyNotFound
+	} else if s.keys[i].static {
+		return "", KeyInfo{}, ErrKeyStatic
+	}
+	old := s.keys[i]
+	s.keys[i] = Key{Name: name, Hash: hash(key), Scopes: old.Scopes, CreatedAt: time.Now().UTC()}
+	err = s.persist()
+	if err != nil {
+		s.keys[i] = old
+		return "", KeyInfo{}, err
+	}
+	return key, info(s.keys[i]), nil
+}
+
+// Revoke removes a key.
+func (s *Store) Revoke(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(name)
+	if i < 0 {
+		return ErrKeyNotFound
+	} else if s.keys[i].static {
+		return ErrKeyStatic
+	}
+	old := append([]Key(nil), s.keys...)
+	s.keys = append(s.keys[:i], s.keys[i+1:]...)
+	err := s.persist()
+	if err != nil {
+		s.keys = old
+		return err
+	}
+	return nil
+}
+
+// find returns the index of the key named name, or -1. It must be called with the mutex held.
+func (s *Store) find(name string) int {
+	for i, k := range s.keys {
+		if k.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// persist saves every key that isn't static, along with their last used times. It must be called
+// with the mutex held.
+func (s *Store) persist() error {
+	if s.save == nil {
+		s.dirty = false
+		return nil
+	}
+	var keys []Key
+	for _, k := range s.keys {
+		if !k.static {
+			keys = append(keys, k)
+		}
+	}
+	err := s.save(keys)
+	if err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+func info(k Key) KeyInfo {
+	return KeyInfo{Name: k.Name, Scopes: k.Scopes, CreatedAt: k.CreatedAt, LastUsed: k.LastUsed, Static: k.static}
+}
+
+func validScopes(scopes []string) bool {
+	if len(scopes) == 0 {
+		return false
+	}
+	for _, scope := range scopes {
+		if scope != ScopeReadLive && scope != ScopeReadHistory && scope != ScopeAdmin {
+			return false
+		}
+	}
+	return true
+}
+
+// generate returns a new random key.
+func generate() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestStoreSavesLastUsedInBackground(t *testing.T) {
+	var saved [][]Key
+	var saveErr error
+	var logs bytes.Buffer
+	s := NewStore(nil, func(keys []Key) error {
+		if saveErr != nil {
+			return saveErr
+		}
+		saved = append(saved, keys)
+		return nil
+	}, log.New(&logs, "", 0))
+	key, _, err := s.Create("client", []string{ScopeReadLive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved = nil
+
+	// Authenticating doesn't save the keys itself
+	_, ok := s.Authenticate(key)
+	if !ok {
+		t.Fatal("key not authenticated")
+	}
+	if len(saved) != 0 {
+		t.Fatalf("keys saved %d times while authenticating, want 0", len(saved))
+	}
+
+	// Failures to save are logged and tried again
+	saveErr = errors.New("disk full")
+	s.flush()
+	if !strings.Contains(logs.String(), "Unable to save API key last used times: disk full") {
+		t.Errorf("save error not logged, got %q", logs.String())
+	}
+	saveErr = nil
+	s.flush()
+	if len(saved) != 1 || saved[0][0].LastUsed == nil {
+		t.Fatalf("saved %v, want the key with its last used time", saved)
+	}
+
+	// Nothing is saved when nothing has changed, including on closing
+	s.flush()
+	s.Close()
+	if len(saved) != 1 {
+		t.Errorf("keys saved %d times, want 1", len(saved))
+	}
+}
+
+func TestStoreSavesLastUsedOnClose(t *testing.T) {
+	var saved [][]Key
+	s := NewStore(nil, func(keys []Key) error {
+		saved = append(saved, keys)
+		return nil
+	}, log.New(&bytes.Buffer{}, "", 0))
+	s.AddStatic("static", "static-key", []string{ScopeAdmin})
+	key, _, err := s.Create("client", []string{ScopeReadLive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved = nil
+
+	// Static keys aren't saved, so using them doesn't need a save
+	s.Authenticate("static-key")
+	s.flush()
+	if len(saved) != 0 {
+		t.Fatalf("keys saved %d times after using a static key, want 0", len(saved))
+	}
+
+	s.Authenticate(key)
+	s.Close()
+	if len(saved) != 1 || len(saved[0]) != 1 || saved[0][0].LastUsed == nil {
+		t.Fatalf("saved %v on closing, want the key with its last used time", saved)
+	}
+}
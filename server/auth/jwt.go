@@ -0,0 +1,482 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha512" // register SHA-384 and SHA-512 for crypto.Hash
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwtLeeway is the clock skew allowed when checking the expiry and not before times of tokens
+	jwtLeeway = time.Minute
+	// jwksRefreshInterval is how often the JWKS is loaded again, so rotated signing keys are picked up
+	jwksRefreshInterval = time.Hour
+	// jwksMinRefresh is how often a token signed by an unknown key can cause the JWKS to be loaded again
+	jwksMinRefresh = time.Minute
+	// jwksMaxSize is the largest JWKS that will be read
+	jwksMaxSize = 1 << 20
+	// minRSAKeyBits is the smallest RSA key accepted
+	minRSAKeyBits = 2048
+)
+
+// esCurves are the curves the ES algorithms sign with.
+var esCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// TokenError is returned when a bearer token is invalid, giving the reason.
+type TokenError struct {
+	Reason string
+}
+
+func (e *TokenError) Error() string {
+	return "invalid token: " + e.Reason
+}
+
+// JWTConfig configures how bearer tokens are verified.
+type JWTConfig struct {
+	// JWKS is the URL or file path of the JSON Web Key Set holding the keys tokens are signed with
+	JWKS     string
+	Issuer   string
+	Audience string
+	// ScopesClaim is the claim holding the token's scopes, either as a space separated string or an
+	// array of strings
+	ScopesClaim string
+	// ScopeMap maps claim values, such as group names, to scopes. Values that aren't mapped are used
+	// if they are scopes themselves.
+	ScopeMap map[string][]string
+}
+
+// JWTVerifier verifies JWT bearer tokens, such as those issued by an OpenID Connect provider,
+// against the keys in a JWKS. It is safe for concurrent use.
+type JWTVerifier struct {
+	config JWTConfig
+	client *http.Client
+	logger *log.Logger
+
+	// loadMu is held while loading the JWKS, so it's only loaded once at a time without holding mu
+	loadMu sync.Mutex
+
+	mu       sync.Mutex
+	keys     map[string]jwk
+	loadedAt time.Time
+	loadErr  error
+}
+
+// jwk is a JSON Web Key, with the public key it describes once parsed.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	key crypto.PublicKey
+}
+
+// jwtHeader is the header of a JWT.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// NewJWTVerifier returns a JWTVerifier for config, logging keys in the JWKS it can't use with
+// logger. The JWKS is loaded on first use if Refresh hasn't been called.
+func NewJWTVerifier(config JWTConfig, logger *log.Logger) *JWTVerifier {
+	if config.ScopesClaim == "" {
+		config.ScopesClaim = "scope"
+	}
+	return &JWTVerifier{config: config, client: &http.Client{Timeout: 10 * time.Second}, logger: logger}
+}
+
+// ParseScopeMap parses a scope map of comma separated value=scope pairs, such as
+// "energy-admins=admin,energy-users=read-live read-history", where a value can map to several
+// space separated scopes.
+func ParseScopeMap(s string) (map[string][]string, error) {
+	scopeMap := map[string][]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid scope map entry %q", pair)
+		}
+		scopes := strings.Fields(parts[1])
+		if !validScopes(scopes) {
+			return nil, fmt.Errorf("invalid scopes for %q", parts[0])
+		}
+		value := strings.TrimSpace(parts[0])
+		scopeMap[value] = append(scopeMap[value], scopes...)
+	}
+	return scopeMap, nil
+}
+
+// Refresh loads the JWKS again.
+func (v *JWTVerifier) Refresh() error {
+	_, loadedAt, _ := v.current()
+	return v.load(loadedAt)
+}
+
+// Verify checks the signature, issuer, audience, expiry and not before time of a token, returning
+// a key named after its subject with the scopes mapped from its claims.
+func (v *JWTVerifier) Verify(token string) (Key, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Key{}, &TokenError{Reason: "malformed token"}
+	}
+
+	// Decode header and claims
+	var header jwtHeader
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return Key{}, &TokenError{Reason: "malformed header"}
+	}
+	var claims map[string]interface{}
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return Key{}, &TokenError{Reason: "malformed claims"}
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Key{}, &TokenError{Reason: "malformed signature"}
+	}
+
+	// Verify signature
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return Key{}, err
+	}
+	if key.Alg != "" && key.Alg != header.Alg {
+		return Key{}, &TokenError{Reason: fmt.Sprintf("algorithm %s doesn't match key", header.Alg)}
+	}
+	err = verifySignature(header.Alg, key.key, []byte(parts[0]+"."+parts[1]), signature)
+	if err != nil {
+		return Key{}, err
+	}
+
+	// Check claims
+	now := time.Now()
+	if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+		return Key{}, &TokenError{Reason: "unexpected issuer"}
+	}
+	if !hasAudience(claims["aud"], v.config.Audience) {
+		return Key{}, &TokenError{Reason: "unexpected audience"}
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return Key{}, &TokenError{Reason: "missing expiry"}
+	} else if now.Add(-jwtLeeway).After(time.Unix(int64(exp), 0)) {
+		return Key{}, &TokenError{Reason: "token has expired"}
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return Key{}, &TokenError{Reason: "token is not valid yet"}
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return Key{}, &TokenError{Reason: "missing subject"}
+	}
+
+	// Map claims to scopes
+	scopes := v.scopes(claims[v.config.ScopesClaim])
+	if len(scopes) == 0 {
+		return Key{}, &TokenError{Reason: "token has no scopes"}
+	}
+	return Key{Name: subject, Scopes: scopes}, nil
+}
+
+// key returns the signing key with ID kid, loading the JWKS again if it's due or the key isn't
+// known, such as after the provider rotates its keys.
+func (v *JWTVerifier) key(kid string) (jwk, error) {
+	keys, loadedAt, _ := v.current()
+	if keys == nil || time.Since(loadedAt) >= jwksRefreshInterval {
+		_ = v.load(loadedAt)
+		var err error
+		keys, loadedAt, err = v.current()
+		if keys == nil {
+			return jwk{}, err
+		}
+	}
+	key, ok := findKey(keys, kid)
+	if !ok && time.Since(loadedAt) >= jwksMinRefresh {
+		err := v.load(loadedAt)
+		if err != nil {
+			return jwk{}, err
+		}
+		keys, _, _ = v.current()
+		key, ok = findKey(keys, kid)
+	}
+	if !ok {
+		return jwk{}, &TokenError{Reason: "unknown signing key"}
+	}
+	return key, nil
+}
+
+// current returns the signing keys, when they were last loaded and the error loading them then.
+// The keys are replaced rather than changed when loaded again, so can be used without the mutex.
+func (v *JWTVerifier) current() (map[string]jwk, time.Time, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.keys, v.loadedAt, v.loadErr
+}
+
+// load loads the JWKS again, unless it has been loaded since loadedAt by another caller while
+// waiting to load it. The JWKS is read without the mutex held, so tokens signed by known keys can
+// still be verified meanwhile. If loading fails the current keys are kept.
+func (v *JWTVerifier) load(loadedAt time.Time) error {
+	v.loadMu.Lock()
+	defer v.loadMu.Unlock()
+	_, lastLoaded, err := v.current()
+	if !lastLoaded.Equal(loadedAt) {
+		return err
+	}
+
+	keys, err := v.read()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.loadedAt = time.Now()
+	v.loadErr = err
+	if err == nil {
+		v.keys = keys
+	}
+	return err
+}
+
+// findKey returns the key with ID kid, or the only key if kid is empty.
+func findKey(keys map[string]jwk, kid string) (jwk, bool) {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	key, ok := keys[kid]
+	return key, ok
+}
+
+// read reads the JWKS from its URL or file, returning the signing keys it can use. Keys that can't
+// be used are skipped, so one bad key doesn't stop the others being used, but at least one is needed.
+func (v *JWTVerifier) read() (map[string]jwk, error) {
+	// Read JWKS
+	var body []byte
+	var err error
+	if strings.HasPrefix(v.config.JWKS, "https://") || strings.HasPrefix(v.config.JWKS, "http://") {
+		body, err = v.fetch(v.config.JWKS)
+	} else {
+		body, err = ioutil.ReadFile(v.config.JWKS)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to load JWKS: %w", err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.Unmarshal(body, &set)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse JWKS: %w", err)
+	}
+
+	// Parse keys
+	keys := map[string]jwk{}
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		key.key, err = key.publicKey()
+		if err != nil {
+			v.logger.Printf("Skipping JWKS key %q: %s\n", key.Kid, err)
+			continue
+		}
+		keys[key.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (v *JWTVerifier) fetch(url string) ([]byte, error) {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, jwksMaxSize))
+}
+
+// scopes returns the scopes mapped from the value of the scopes claim.
+func (v *JWTVerifier) scopes(claim interface{}) []string {
+	var values []string
+	switch claim := claim.(type) {
+	case string:
+		values = strings.Fields(claim)
+	case []interface{}:
+		for _, value := range claim {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	var scopes []string
+	seen := map[string]bool{}
+	for _, value := range values {
+		mapped, ok := v.config.ScopeMap[value]
+		if !ok {
+			mapped = []string{value}
+		}
+		for _, scope := range mapped {
+			if validScopes([]string{scope}) && !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
+}
+
+// publicKey returns the RSA or EC public key described by the JWK.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA key")
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+		if key.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA key is smaller than %d bits", minRSAKeyBits)
+		}
+		return key, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("invalid EC key")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifySignature checks the signature of signed with the RS, PS or ES algorithm alg. Any other
+// algorithm, including none and the HS algorithms, is rejected.
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	if len(alg) != 5 {
+		return &TokenError{Reason: fmt.Sprintf("unsupported algorithm %q", alg)}
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return &TokenError{Reason: fmt.Sprintf("unsupported algorithm %q", alg)}
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	valid := false
+	switch alg[:2] {
+	case "RS":
+		if key, ok := key.(*rsa.PublicKey); ok {
+			valid = rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+		}
+	case "PS":
+		if key, ok := key.(*rsa.PublicKey); ok {
+			valid = rsa.VerifyPSS(key, hash, digest, signature, nil) == nil
+		}
+	case "ES":
+		if key, ok := key.(*ecdsa.PublicKey); ok {
+			// Each ES algorithm only signs with its own curve
+			if key.Curve != esCurves[alg] {
+				return &TokenError{Reason: fmt.Sprintf("algorithm %s doesn't match key", alg)}
+			}
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if len(signature) == 2*size {
+				r := new(big.Int).SetBytes(signature[:size])
+				s := new(big.Int).SetBytes(signature[size:])
+				valid = ecdsa.Verify(key, digest, r, s)
+			}
+		}
+	default:
+		return &TokenError{Reason: fmt.Sprintf("unsupported algorithm %q", alg)}
+	}
+	if !valid {
+		return &TokenError{Reason: "invalid signature"}
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
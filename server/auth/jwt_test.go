@@ -0,0 +1,360 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "geo-energy-data"
+)
+
+// jwksServer is a stand-in for an OpenID Connect provider's JWKS endpoint, counting fetches.
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []jwk
+	block   chan struct{}
+	fetches int32
+}
+
+func newJWKSServer(t *testing.T, keys ...jwk) *jwksServer {
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.fetches, 1)
+		s.mu.Lock()
+		keys, block := s.keys, s.block
+		s.mu.Unlock()
+		if block != nil {
+			<-block
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// setKeys changes the keys served, such as when the provider rotates them.
+func (s *jwksServer) setKeys(keys ...jwk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func rsaJWK(t *testing.T, kid string, bits int) (*rsa.PrivateKey, jwk) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, jwk{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(t *testing.T, kid string) (*ecdsa.PrivateKey, jwk) {
+	return ecCurveJWK(t, kid, elliptic.P256())
+}
+
+func ecCurveJWK(t *testing.T, kid string, curve elliptic.Curve) (*ecdsa.PrivateKey, jwk) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	return key, jwk{
+		Kty: "EC",
+		Kid: kid,
+		Crv: curve.Params().Name,
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+// signToken returns a token with the header and claims, signed with key by alg. A nil key leaves
+// the signature empty, and a []byte key signs with HMAC.
+func signToken(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	header, err := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		if err == nil {
+			size := (key.Curve.Params().BitSize + 7) / 8
+			signature = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+		}
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// testClaims returns valid claims for the test issuer and audience, changed by changes.
+func testClaims(changes map[string]interface{}) map[string]interface{} {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   testIssuer,
+		"aud":   testAudience,
+		"sub":   "user@example.com",
+		"exp":   now.Add(time.Hour).Unix(),
+		"nbf":   now.Add(-time.Minute).Unix(),
+		"scope": "read-live read-history",
+	}
+	for name, value := range changes {
+		claims[name] = value
+	}
+	return claims
+}
+
+func newTestVerifier(url string) *JWTVerifier {
+	return NewJWTVerifier(JWTConfig{JWKS: url, Issuer: testIssuer, Audience: testAudience}, log.New(ioutil.Discard, "", 0))
+}
+
+func TestJWTVerify(t *testing.T) {
+	rsaKey, rsaPublic := rsaJWK(t, "rsa", 2048)
+	ecKey, ecPublic := ecJWK(t, "ec")
+	p384Key, p384Public := ecCurveJWK(t, "p384", elliptic.P384())
+	server := newJWKSServer(t, rsaPublic, ecPublic, p384Public)
+	v := newTestVerifier(server.URL)
+
+	// HS256 signed with the RSA public key, in case it's used as the HMAC secret
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		reason string
+	}{
+		{"RS256", signToken(t, "RS256", "rsa", rsaKey, testClaims(nil)), ""},
+		{"ES256", signToken(t, "ES256", "ec", ecKey, testClaims(nil)), ""},
+		{"wrong issuer", signToken(t, "RS256", "rsa", rsaKey, testClaims(map[string]interface{}{"iss": "https://other.example.com"})), "unexpected issuer"},
+		{"wrong audience", signToken(t, "RS256", "rsa", rsaKey, testClaims(map[string]interface{}{"aud": []string{"other"}})), "unexpected audience"},
+		{"expired", signToken(t, "ES256", "ec", ecKey, testClaims(map[string]interface{}{"exp": time.Now().Add(-2 * jwtLeeway).Unix()})), "token has expired"},
+		{"missing subject", signToken(t, "RS256", "rsa", rsaKey, testClaims(map[string]interface{}{"sub": ""})), "missing subject"},
+		{"not valid yet", signToken(t, "ES256", "ec", ecKey, testClaims(map[string]interface{}{"nbf": time.Now().Add(2 * jwtLeeway).Unix()})), "token is not valid yet"},
+		{"wrong key", signToken(t, "ES256", "ec", rsaKey, testClaims(nil)), "invalid signature"},
+		{"ES256 with P-384 key", signToken(t, "ES256", "p384", p384Key, testClaims(nil)), "algorithm ES256 doesn't match key"},
+		{"alg none", signToken(t, "none", "ec", nil, testClaims(nil)), `unsupported algorithm "none"`},
+		{"alg none without kid", signToken(t, "none", "", nil, testClaims(nil)), "unknown signing key"},
+		{"HS256 with RSA key", signToken(t, "HS256", "rsa", publicDER, testClaims(nil)), "algorithm HS256 doesn't match key"},
+		{"HS256 with EC key", signToken(t, "HS256", "ec", []byte(ecPublic.X), testClaims(nil)), `unsupported algorithm "HS256"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := v.Verify(test.token)
+			if test.reason == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				want := Key{Name: "user@example.com", Scopes: []string{ScopeReadLive, ScopeReadHistory}}
+				if !reflect.DeepEqual(key, want) {
+					t.Errorf("key = %+v, want %+v", key, want)
+				}
+				return
+			}
+			var tokenErr *TokenError
+			if !errors.As(err, &tokenErr) {
+				t.Fatalf("error = %v, want a TokenError", err)
+			}
+			if tokenErr.Reason != test.reason {
+				t.Errorf("reason = %q, want %q", tokenErr.Reason, test.reason)
+			}
+		})
+	}
+	if fetches := atomic.LoadInt32(&server.fetches); fetches != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", fetches)
+	}
+}
+
+func TestJWTUnknownKeyRefetches(t *testing.T) {
+	_, oldPublic := ecJWK(t, "old")
+	newKey, newPublic := ecJWK(t, "new")
+	server := newJWKSServer(t, oldPublic)
+	v := newTestVerifier(server.URL)
+	err := v.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys aren't fetched again more than once a minute
+	server.setKeys(oldPublic, newPublic)
+	token := signToken(t, "ES256", "new", newKey, testClaims(nil))
+	_, err = v.Verify(token)
+	var tokenErr *TokenError
+	if !errors.As(err, &tokenErr) || tokenErr.Reason != "unknown signing key" {
+		t.Fatalf("error = %v, want unknown signing key", err)
+	}
+	if fetches := atomic.LoadInt32(&server.fetches); fetches != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", fetches)
+	}
+
+	// After a minute the rotated key is fetched
+	v.mu.Lock()
+	v.loadedAt = v.loadedAt.Add(-jwksMinRefresh)
+	v.mu.Unlock()
+	_, err = v.Verify(token)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fetches := atomic.LoadInt32(&server.fetches); fetches != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", fetches)
+	}
+}
+
+func TestJWTJWKSDown(t *testing.T) {
+	key, public := ecJWK(t, "ec")
+	token := signToken(t, "ES256", "ec", key, testClaims(nil))
+	server := newJWKSServer(t, public)
+	server.Close()
+
+	// Tokens can't be verified without the keys, which isn't the token's fault
+	v := newTestVerifier(server.URL)
+	_, err := v.Verify(token)
+	var tokenErr *TokenError
+	if err == nil || errors.As(err, &tokenErr) {
+		t.Fatalf("error = %v, want a JWKS error", err)
+	}
+
+	// Keys already loaded are kept when the JWKS can't be loaded again
+	up := newJWKSServer(t, public)
+	v.config.JWKS = up.URL
+	err = v.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.config.JWKS = server.URL
+	v.mu.Lock()
+	v.loadedAt = v.loadedAt.Add(-jwksRefreshInterval)
+	v.mu.Unlock()
+	_, err = v.Verify(token)
+	if err != nil {
+		t.Fatalf("unexpected error with stale keys: %s", err)
+	}
+	_, _, err = v.current()
+	if err == nil {
+		t.Error("expected the JWKS error to be kept")
+	}
+}
+
+func TestJWTVerifyWhileLoading(t *testing.T) {
+	key, public := ecJWK(t, "ec")
+	server := newJWKSServer(t, public)
+	v := newTestVerifier(server.URL)
+	err := v.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens signed by known keys are verified while the JWKS is slow to load
+	block := make(chan struct{})
+	server.mu.Lock()
+	server.block = block
+	server.mu.Unlock()
+	refreshed := make(chan error)
+	go func() {
+		refreshed <- v.Refresh()
+	}()
+	for atomic.LoadInt32(&server.fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	verified := make(chan error)
+	go func() {
+		_, err := v.Verify(signToken(t, "ES256", "ec", key, testClaims(nil)))
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("token not verified while the JWKS was loading")
+	}
+	close(block)
+	if err := <-refreshed; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJWTRejectsShortRSAKeys(t *testing.T) {
+	_, public := rsaJWK(t, "short", 1024)
+	server := newJWKSServer(t, public)
+	v := newTestVerifier(server.URL)
+	err := v.Refresh()
+	if err == nil {
+		t.Fatal("expected a 1024 bit RSA key to be rejected")
+	}
+}
+
+func TestJWTSkipsUnusableKeys(t *testing.T) {
+	_, short := rsaJWK(t, "short", 1024)
+	key, public := ecJWK(t, "ec")
+	secret := jwk{Kty: "oct", Kid: "secret"}
+	server := newJWKSServer(t, short, secret, public)
+	var logs strings.Builder
+	v := NewJWTVerifier(JWTConfig{JWKS: server.URL, Issuer: testIssuer, Audience: testAudience}, log.New(&logs, "", 0))
+
+	// The usable key is loaded, and the others are logged
+	err := v.Refresh()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, kid := range []string{"short", "secret"} {
+		if !strings.Contains(logs.String(), `Skipping JWKS key "`+kid+`"`) {
+			t.Errorf("key %s not logged as skipped: %s", kid, logs.String())
+		}
+	}
+	_, err = v.Verify(signToken(t, "ES256", "ec", key, testClaims(nil)))
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"flag"
+	"github.com/olivercullimore/geo-energy-data/server/backfill"
+	"os"
+)
+
+// Backfill runs the backfill command, writing historical consumption for a date range to
+// InfluxDB. Running it again with the same range resumes an interrupted backfill.
+func Backfill(args []string) {
+	// Parse arguments
+	yesterday := backfill.Yesterday().Format(backfill.DateFormat)
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	fromStr := flags.String("from", "", "first day to backfill in YYYY-MM-DD format")
+	toStr := flags.String("to", yesterday, "last day to backfill in YYYY-MM-DD format")
+	_ = flags.Parse(args)
+
+	// Set up from environment variables and config
+	a := setup()
+	env := a.env
+	if env.Backfill == nil {
+		env.Logger.Fatalf("Backfill requires InfluxDB to be enabled\n")
+	}
+
+	// Validate date range
+	from, err := backfill.ParseDate(*fromStr)
+	if err != nil {
+		env.Logger.Fatalf("Invalid from date %q, expected YYYY-MM-DD\n", *fromStr)
+	}
+	to, err := backfill.ParseDate(*toStr)
+	if err != nil {
+		env.Logger.Fatalf("Invalid to date %q, expected YYYY-MM-DD\n", *toStr)
+	}
+	if from.After(to) {
+		env.Logger.Fatalf("Backfill from date must not be after the to date\n")
+	}
+
+	// Run backfill
+	err = env.Backfill.Run(from, to)
+	a.influxDB.Close()
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,246 @@
+package backfill
+
+import (
+	"errors"
+	"github.com/olivercullimore/geo-energy-data/server/geoapi"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"github.com/olivercullimore/go-utils/configfile"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DateFormat is the format of backfill dates
+	DateFormat = "2006-01-02"
+)
+
+var (
+	// ErrRunning is returned when starting a backfill while another is running.
+	ErrRunning = errors.New("a backfill is already running")
+	// ErrInvalidRange is returned when the start of a backfill is after its end.
+	ErrInvalidRange = errors.New("backfill from date must not be after the to date")
+	// ErrStopped is returned when a backfill is stopped, or started after the Runner was stopped.
+	ErrStopped = errors.New("backfill stopped")
+)
+
+// Writer writes meter readings.
+type Writer interface {
+	Write(readings models.Readings) error
+}
+
+// Runner fetches historical daily consumption of every system from geo and writes it as meter
+// readings. Readings have fixed timestamps so days can be backfilled more than once without
+// duplicating data. Only one backfill runs at a time.
+type Runner struct {
+	systems   []models.System
+	writer    Writer
+	schema    models.Schema
+	stateFile string
+	logger    *log.Logger
+	mu        sync.Mutex
+	progress  models.BackfillProgress
+	stopped   bool
+	wg        sync.WaitGroup
+}
+
+// NewRunner returns a Runner for systems that writes readings with schema and saves its progress
+// to stateFile, loading any progress saved by a previous run.
+func NewRunner(systems []models.System, writer Writer, schema models.Schema, stateFile string, logger *log.Logger) *Runner {
+	r := &Runner{systems: systems, writer: writer, schema: schema, stateFile: stateFile, logger: logger}
+	if _, err := os.Stat(stateFile); err == nil {
+		err = configfile.Load(stateFile, &r.progress)
+		if err != nil {
+			logger.Printf("Unable to load backfill progress: %s\n", err)
+		}
+		r.progress.Running = false
+	}
+	return r
+}
+
+// Progress returns the progress of the current or most recent backfill.
+func (r *Runner) Progress() models.BackfillProgress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.progress
+}
+
+// Start starts backfilling the days from from to to inclusive in the background.
+func (r *Runner) Start(from, to time.Time) error {
+	err := r.begin(from, to)
+	if err != nil {
+		return err
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		_ = r.run()
+	}()
+	return nil
+}
+
+// Stop stops a backfill started in the background once it has finished the day it's on, waiting
+// for it to stop. Its progress is kept so it can be resumed. No backfills can be started after.
+func (r *Runner) Stop() {
+	r.mu.Lock()
+	r.stopped = true
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+// Run backfills the days from from to to inclusive, returning once finished.
+func (r *Runner) Run(from, to time.Time) error {
+	err := r.begin(from, to)
+	if err != nil {
+		return err
+	}
+	return r.run()
+}
+
+// Today returns the start of the current day in UTC.
+func Today() time.Time {
+	return truncateDay(time.Now())
+}
+
+// Yesterday returns the start of the previous day in UTC, the last complete day.
+func Yesterday() time.Time {
+	return Today().AddDate(0, 0, -1)
+}
+
+// ParseDate parses a backfill date.
+func ParseDate(value string) (time.Time, error) {
+	return time.ParseInLocation(DateFormat, value, time.UTC)
+}
+
+// begin marks a backfill as running, resuming the saved progress if it is for the same range.
+func (r *Runner) begin(from, to time.Time) error {
+	from = truncateDay(from)
+	to = truncateDay(to)
+	if from.After(to) {
+		return ErrInvalidRange
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return ErrStopped
+	}
+	if r.progress.Running {
+		return ErrRunning
+	}
+
+	fromStr := from.Format(DateFormat)
+	toStr := to.Format(DateFormat)
+	if r.progress.From == fromStr && r.progress.To == toStr && !r.progress.Completed && r.progress.Next != "" {
+		r.logger.Printf("Resuming backfill from %s to %s at %s\n", fromStr, toStr, r.progress.Next)
+	} else {
+		r.progress = models.BackfillProgress{
+			From:      fromStr,
+			To:        toStr,
+			Next:      fromStr,
+			DaysTotal: int(to.Sub(from).Hours()/24) + 1,
+		}
+		r.logger.Printf("Starting backfill from %s to %s\n", fromStr, toStr)
+	}
+	r.progress.Running = true
+	r.progress.Error = ""
+	return nil
+}
+
+func (r *Runner) run() error {
+	progress := r.Progress()
+	day, err := ParseDate(progress.Next)
+	if err != nil {
+		return r.fail(err)
+	}
+	to, err := ParseDate(progress.To)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	for !day.After(to) {
+		r.mu.Lock()
+		stopped := r.stopped
+		r.mu.Unlock()
+		if stopped {
+			return r.fail(ErrStopped)
+		}
+
+		// Backfill the day for each system, starting the day again if resumed part way through
+		records := 0
+		for _, system := range r.systems {
+			// Get history for the day
+			historicData, err := system.Geo.GetHistoricDayData(system.ID, day, day.Add(24*time.Hour-time.Second))
+			if err != nil {
+				return r.fail(err)
+			}
+
+			// Write readings for the day
+			readings := Readings(system, historicData, day)
+			if !readings.Empty() {
+				err = r.writer.Write(readings)
+				if err != nil {
+					return r.fail(err)
+				}
+				records += len(readings.Points(r.schema))
+			}
+		}
+
+		// Save progress
+		day = day.Add(24 * time.Hour)
+		r.mu.Lock()
+		r.progress.Next = day.Format(DateFormat)
+		r.progress.DaysDone++
+		r.progress.Records += records
+		r.progress.Completed = day.After(to)
+		r.progress.Running = !r.progress.Completed
+		progress = r.progress
+		r.mu.Unlock()
+		r.save(progress)
+		r.logger.Printf("Backfilled %s (%d/%d days, %d records)\n", day.Add(-24*time.Hour).Format(DateFormat), progress.DaysDone, progress.DaysTotal, progress.Records)
+	}
+
+	r.logger.Printf("Finished backfill from %s to %s\n", progress.From, progress.To)
+	return nil
+}
+
+// fail records a backfill as stopped by err and returns err.
+func (r *Runner) fail(err error) error {
+	r.mu.Lock()
+	r.progress.Running = false
+	r.progress.Error = err.Error()
+	progress := r.progress
+	r.mu.Unlock()
+	r.save(progress)
+	r.logger.Printf("Backfill stopped at %s: %s\n", progress.Next, err)
+	return err
+}
+
+func (r *Runner) save(progress models.BackfillProgress) {
+	err := configfile.Save(r.stateFile, &progress)
+	if err != nil {
+		r.logger.Printf("Unable to save backfill progress: %s\n", err)
+	}
+}
+
+// Readings converts the history of a system for a day to cost readings, timestamped at the last
+// second of the day so backfilling a day again overwrites the same points. They are tagged with
+// the history source, so are kept apart from the current costs recorded by the periodic scheduler,
+// which are timestamped when they were fetched.
+func Readings(system models.System, historicData geoapi.HistoricDayData, day time.Time) models.Readings {
+	readings := models.Readings{System: system.ID, SystemName: system.Name, Source: models.SourceHistory, FetchedAt: time.Now()}
+	for _, item := range historicData.TotalsList {
+		timestamp := day.Add(24*time.Hour - time.Second).Unix()
+		if item.Year > 0 && item.Month > 0 && item.Day > 0 {
+			timestamp = time.Date(item.Year, time.Month(item.Month), item.Day, 23, 59, 59, 0, time.UTC).Unix()
+		}
+		readings.Costs = append(readings.Costs, models.CostReading{Commodity: item.CommodityType, Duration: "DAY", Cost: item.CostAmount, Energy: item.EnergyAmount, Timestamp: timestamp})
+	}
+	return readings
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -2,37 +2,47 @@ package controllers
 
 import (
 	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
 	"github.com/olivercullimore/geo-energy-data-client"
+	"github.com/olivercullimore/geo-energy-data/server/auth"
+	"github.com/olivercullimore/geo-energy-data/server/backfill"
+	"github.com/olivercullimore/geo-energy-data/server/geoapi"
 	"github.com/olivercullimore/geo-energy-data/server/models"
+	"github.com/olivercullimore/geo-energy-data/server/snapshot"
 	"log"
 	"net/http"
-	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// streamHeartbeatInterval is how often a comment is sent to keep idle streams open
+	streamHeartbeatInterval = 15 * time.Second
+	// streamWriteTimeout is the longest writing a single stream event is allowed to take
+	streamWriteTimeout = 10 * time.Second
+	// streamRetry is how long clients should wait before reconnecting to a stream
+	streamRetry = 5 * time.Second
+	// maxHistoryPoints is the most points a history request can return for each commodity
+	maxHistoryPoints = 10000
 )
 
 func APIGetCurrentUsage(env *models.Env, w http.ResponseWriter, r *http.Request) {
-	// Get an access token
-	accessToken, err := geo.GetAccessToken(env.GeoUser, env.GeoPass)
-	checkErr(err, env.Logger)
+	// Get requested system
+	system, ok := requestSystem(env, w, r)
+	if !ok {
+		return
+	}
 
 	// Get live meter data
-	liveData, err := geo.GetLiveMeterData(accessToken, env.Config.GeoSystemID)
-	checkErr(err, env.Logger)
+	liveData, _, ok := liveMeterData(env, w, r, system)
+	if !ok {
+		return
+	}
 
 	// Set available power readings
-	liveUsage := models.LiveUsage{Electricity: models.LiveUsageData{}, Gas: models.LiveUsageData{}}
-	if liveData.PowerTimestamp > 0 && len(liveData.Power) > 0 {
-		for _, item := range liveData.Power {
-			if item.ValueAvailable {
-				if item.Type == "GAS_ENERGY" {
-					liveUsage.Gas.Watts = item.Watts
-					liveUsage.Gas.LastUpdated = liveData.PowerTimestamp
-				} else if item.Type == "ELECTRICITY" {
-					liveUsage.Electricity.Watts = item.Watts
-					liveUsage.Electricity.LastUpdated = liveData.PowerTimestamp
-				}
-			}
-		}
-	}
+	liveUsage := models.LiveReadings(system, liveData, time.Now()).LiveUsage()
 
 	// Return available power readings
 	if liveUsage.Electricity.LastUpdated > 0 || liveUsage.Gas.LastUpdated > 0 {
@@ -40,46 +50,32 @@ func APIGetCurrentUsage(env *models.Env, w http.ResponseWriter, r *http.Request)
 		if env.DebugMode {
 			outputJSON(liveUsage, "Current usage data", env.Logger)
 		}
-		err = respondWithJSON(w, http.StatusOK, liveUsage)
+		err := respondWithJSON(w, http.StatusOK, liveUsage)
 		if err != nil {
-			env.Logger.Fatal(err)
+			env.Logger.Println(err)
 			return
 		}
 	} else {
-		err := respondWithError(w, http.StatusNoContent, "No live usage data available")
-		if err != nil {
-			env.Logger.Fatal(err)
-			return
-		}
+		// No data available
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
 func APIGetMeterReadings(env *models.Env, w http.ResponseWriter, r *http.Request) {
-	// Get an access token
-	accessToken, err := geo.GetAccessToken(env.GeoUser, env.GeoPass)
-	checkErr(err, env.Logger)
+	// Get requested system
+	system, ok := requestSystem(env, w, r)
+	if !ok {
+		return
+	}
 
 	// Get periodic meter data
-	periodicData, err := geo.GetPeriodicMeterData(accessToken, env.Config.GeoSystemID)
-	checkErr(err, env.Logger)
+	periodicData, _, ok := periodicMeterData(env, w, r, system)
+	if !ok {
+		return
+	}
 
 	// Set available power readings
-	periodicUsage := models.PeriodicUsage{Electricity: models.PeriodicUsageData{}, Gas: models.PeriodicUsageData{}}
-	if periodicData.TotalConsumptionTimestamp > 0 && len(periodicData.TotalConsumptionList) > 0 {
-		for _, item := range periodicData.TotalConsumptionList {
-			if item.ValueAvailable {
-				if item.CommodityType == "GAS_ENERGY" {
-					periodicUsage.Gas.TotalConsumption = item.TotalConsumption
-					periodicUsage.Gas.ReadingTime = item.ReadingTime
-					periodicUsage.Gas.Unit = "m3"
-				} else if item.CommodityType == "ELECTRICITY" {
-					periodicUsage.Electricity.TotalConsumption = item.TotalConsumption
-					periodicUsage.Electricity.ReadingTime = item.ReadingTime
-					periodicUsage.Electricity.Unit = "watts"
-				}
-			}
-		}
-	}
+	periodicUsage := models.PeriodicReadings(system, periodicData, env.Config.CalorificValue, time.Now()).PeriodicUsage()
 
 	// Return available power readings
 	if periodicUsage.Electricity.ReadingTime > 0 || periodicUsage.Gas.ReadingTime > 0 {
@@ -87,28 +83,29 @@ func APIGetMeterReadings(env *models.Env, w http.ResponseWriter, r *http.Request
 		if env.DebugMode {
 			outputJSON(periodicUsage, "Meter readings data", env.Logger)
 		}
-		err = respondWithJSON(w, http.StatusOK, periodicUsage)
+		err := respondWithJSON(w, http.StatusOK, periodicUsage)
 		if err != nil {
-			env.Logger.Fatal(err)
+			env.Logger.Println(err)
 			return
 		}
 	} else {
-		err := respondWithError(w, http.StatusNoContent, "No periodic usage data available")
-		if err != nil {
-			env.Logger.Fatal(err)
-			return
-		}
+		// No data available
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
 func APIGetLiveData(env *models.Env, w http.ResponseWriter, r *http.Request) {
-	// Get an access token
-	accessToken, err := geo.GetAccessToken(env.GeoUser, env.GeoPass)
-	checkErr(err, env.Logger)
+	// Get requested system
+	system, ok := requestSystem(env, w, r)
+	if !ok {
+		return
+	}
 
 	// Get live meter data
-	liveData, err := geo.GetLiveMeterData(accessToken, env.Config.GeoSystemID)
-	checkErr(err, env.Logger)
+	liveData, _, ok := liveMeterData(env, w, r, system)
+	if !ok {
+		return
+	}
 
 	// Debug output
 	if env.DebugMode {
@@ -117,28 +114,29 @@ func APIGetLiveData(env *models.Env, w http.ResponseWriter, r *http.Request) {
 
 	// Return data
 	if liveData.ID != "" {
-		err = respondWithJSON(w, http.StatusOK, liveData)
+		err := respondWithJSON(w, http.StatusOK, liveData)
 		if err != nil {
-			env.Logger.Fatal(err)
+			env.Logger.Println(err)
 			return
 		}
 	} else {
-		err := respondWithError(w, http.StatusNoContent, "No data available")
-		if err != nil {
-			env.Logger.Fatal(err)
-			return
-		}
+		// No data available
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
 func APIGetPeriodicData(env *models.Env, w http.ResponseWriter, r *http.Request) {
-	// Get an access token
-	accessToken, err := geo.GetAccessToken(env.GeoUser, env.GeoPass)
-	checkErr(err, env.Logger)
+	// Get requested system
+	system, ok := requestSystem(env, w, r)
+	if !ok {
+		return
+	}
 
 	// Get periodic meter data
-	periodicData, err := geo.GetPeriodicMeterData(accessToken, env.Config.GeoSystemID)
-	checkErr(err, env.Logger)
+	periodicData, _, ok := periodicMeterData(env, w, r, system)
+	if !ok {
+		return
+	}
 
 	// Debug output
 	if env.DebugMode {
@@ -147,68 +145,694 @@ func APIGetPeriodicData(env *models.Env, w http.ResponseWriter, r *http.Request)
 
 	// Return data
 	if periodicData.ID != "" {
-		err = respondWithJSON(w, http.StatusOK, periodicData)
+		err := respondWithJSON(w, http.StatusOK, periodicData)
 		if err != nil {
-			env.Logger.Fatal(err)
+			env.Logger.Println(err)
 			return
 		}
 	} else {
-		err := respondWithError(w, http.StatusNoContent, "No data available")
+		// No data available
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func APIGetLiveReadings(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Get requested system
+	system, ok := requestSystem(env, w, r)
+	if !ok {
+		return
+	}
+
+	// Get live meter data
+	liveData, fetchedAt, ok := liveMeterData(env, w, r, system)
+	if !ok {
+		return
+	}
+
+	// Return readings
+	err := respondWithJSON(w, http.StatusOK, models.LiveReadings(system, liveData, fetchedAt))
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+func APIGetPeriodicReadings(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Get requested system
+	system, ok := requestSystem(env, w, r)
+	if !ok {
+		return
+	}
+
+	// Get periodic meter data
+	periodicData, fetchedAt, ok := periodicMeterData(env, w, r, system)
+	if !ok {
+		return
+	}
+
+	// Return readings
+	err := respondWithJSON(w, http.StatusOK, models.PeriodicReadings(system, periodicData, env.Config.CalorificValue, fetchedAt))
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+func APIGetBills(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Get requested system
+	system, ok := requestSystem(env, w, r)
+	if !ok {
+		return
+	}
+
+	// Get periodic meter data
+	periodicData, fetchedAt, ok := periodicMeterData(env, w, r, system)
+	if !ok {
+		return
+	}
+
+	// Return bills
+	err := respondWithJSON(w, http.StatusOK, models.NewBillsResponse(system, periodicData, env.Schema.Currency, fetchedAt))
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+func APIGetTariffs(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Get requested system
+	system, ok := requestSystem(env, w, r)
+	if !ok {
+		return
+	}
+
+	// Get periodic meter data
+	periodicData, fetchedAt, ok := periodicMeterData(env, w, r, system)
+	if !ok {
+		return
+	}
+
+	// Return tariffs
+	err := respondWithJSON(w, http.StatusOK, models.NewTariffsResponse(system, periodicData, env.Schema.Currency, fetchedAt))
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+func APIGetCosts(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Get requested system
+	system, ok := requestSystem(env, w, r)
+	if !ok {
+		return
+	}
+
+	// Validate duration
+	duration := strings.ToUpper(r.URL.Query().Get("duration"))
+	if duration != "" && duration != models.DurationDay && duration != models.DurationWeek && duration != models.DurationMonth {
+		respondWithBadRequest(env, w, "Invalid duration, expected DAY, WEEK or MONTH")
+		return
+	}
+
+	// Get periodic meter data
+	periodicData, fetchedAt, ok := periodicMeterData(env, w, r, system)
+	if !ok {
+		return
+	}
+
+	// Return costs
+	err := respondWithJSON(w, http.StatusOK, models.NewCostsResponse(system, periodicData, env.Schema.Currency, duration, fetchedAt))
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+func APIGetSystems(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	systems := env.Systems
+	if systems == nil {
+		systems = []models.System{}
+	}
+	err := respondWithJSON(w, http.StatusOK, models.SystemsResponse{Systems: systems})
+	if err != nil {
+		env.Logger.Println(err)
+		return
+	}
+}
+
+func APIGetBackfill(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Check backfill is available
+	if env.Backfill == nil {
+		respondWithUnavailable(env, w, "Backfill requires InfluxDB to be enabled")
+		return
+	}
+
+	// Return backfill progress
+	err := respondWithJSON(w, http.StatusOK, env.Backfill.Progress())
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+func APIStartBackfill(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Check backfill is available
+	if env.Backfill == nil {
+		respondWithUnavailable(env, w, "Backfill requires InfluxDB to be enabled")
+		return
+	}
+
+	// Get date range, defaulting to end yesterday
+	from, err := backfill.ParseDate(r.URL.Query().Get("from"))
+	if err != nil {
+		respondWithBadRequest(env, w, "Invalid from date, expected YYYY-MM-DD")
+		return
+	}
+	to := backfill.Yesterday()
+	if r.URL.Query().Get("to") != "" {
+		to, err = backfill.ParseDate(r.URL.Query().Get("to"))
 		if err != nil {
-			env.Logger.Fatal(err)
+			respondWithBadRequest(env, w, "Invalid to date, expected YYYY-MM-DD")
 			return
 		}
 	}
+
+	// Start backfill
+	err = env.Backfill.Start(from, to)
+	if err == backfill.ErrRunning {
+		err = respondWithError(w, http.StatusConflict, err.Error())
+		if err != nil {
+			env.Logger.Println(err)
+		}
+		return
+	}
+	if err != nil {
+		respondWithBadRequest(env, w, err.Error())
+		return
+	}
+	err = respondWithJSON(w, http.StatusAccepted, env.Backfill.Progress())
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+func APIGetKeys(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	err := respondWithJSON(w, http.StatusOK, models.APIKeysResponse{Keys: env.Keys.Keys()})
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+func APICreateKey(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Decode request
+	var req models.APIKeyRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req)
+	if err != nil {
+		respondWithBadRequest(env, w, "Invalid request body, expected JSON with a name and scopes")
+		return
+	}
+
+	// Create key
+	key, info, err := env.Keys.Create(req.Name, req.Scopes)
+	if err != nil {
+		respondWithKeyError(env, w, err)
+		return
+	}
+	env.Logger.Printf("Created API key %s\n", info.Name)
+	err = respondWithJSON(w, http.StatusCreated, models.NewAPIKeyResponse{KeyInfo: info, Key: key})
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+func APIRotateKey(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Rotate key
+	key, info, err := env.Keys.Rotate(mux.Vars(r)["name"])
+	if err != nil {
+		respondWithKeyError(env, w, err)
+		return
+	}
+	env.Logger.Printf("Rotated API key %s\n", info.Name)
+	err = respondWithJSON(w, http.StatusOK, models.NewAPIKeyResponse{KeyInfo: info, Key: key})
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+func APIRevokeKey(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Revoke key
+	name := mux.Vars(r)["name"]
+	err := env.Keys.Revoke(name)
+	if err != nil {
+		respondWithKeyError(env, w, err)
+		return
+	}
+	env.Logger.Printf("Revoked API key %s\n", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func APIGetPowerHistory(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Get requested system and history query
+	system, ok := requestSystem(env, w, r)
+	if !ok {
+		return
+	}
+	query, ok := historyQuery(env, w, r, system, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	// Validate step and aggregate
+	query.Step = 5 * time.Minute
+	if stepStr := r.URL.Query().Get("step"); stepStr != "" {
+		step, err := time.ParseDuration(stepStr)
+		if err != nil {
+			seconds, convErr := strconv.Atoi(stepStr)
+			step, err = time.Second*time.Duration(seconds), convErr
+		}
+		if err != nil || step < time.Second {
+			respondWithBadRequest(env, w, "Invalid step, expected a duration such as 30s, 5m or 1h")
+			return
+		}
+		query.Step = step
+	}
+	if query.To.Sub(query.From)/query.Step > maxHistoryPoints {
+		respondWithBadRequest(env, w, "Too many points requested, increase the step or shorten the range")
+		return
+	}
+	query.Aggregate = r.URL.Query().Get("agg")
+	if query.Aggregate == "" {
+		query.Aggregate = models.AggregateMean
+	}
+	if query.Aggregate != models.AggregateMean && query.Aggregate != models.AggregateMax && query.Aggregate != models.AggregateMin {
+		respondWithBadRequest(env, w, "Invalid agg, expected mean, max or min")
+		return
+	}
+
+	// Get power history
+	series, err := env.History.Power(query)
+	if err != nil {
+		env.Logger.Printf("History query error: %s\n", err)
+		respondWithBadGateway(env, w, "Unable to query history")
+		return
+	}
+	respondWithHistory(env, w, models.HistoryResponse{System: system.ID, From: query.From, To: query.To, Step: query.Step.String(), Aggregate: query.Aggregate, Series: series})
+}
+
+func APIGetEnergyHistory(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Get requested system and history query
+	system, ok := requestSystem(env, w, r)
+	if !ok {
+		return
+	}
+	query, ok := historyQuery(env, w, r, system, 30*24*time.Hour)
+	if !ok {
+		return
+	}
+
+	// Validate interval
+	query.Interval = r.URL.Query().Get("interval")
+	if query.Interval == "" {
+		query.Interval = models.IntervalDay
+	}
+	switch query.Interval {
+	case models.IntervalHour, models.IntervalDay, models.IntervalWeek, models.IntervalMonth:
+	default:
+		respondWithBadRequest(env, w, "Invalid interval, expected hour, day, week or month")
+		return
+	}
+	if query.Interval == models.IntervalHour && query.To.Sub(query.From)/time.Hour > maxHistoryPoints {
+		respondWithBadRequest(env, w, "Too many points requested, increase the interval or shorten the range")
+		return
+	}
+
+	// Get energy history
+	series, err := env.History.Energy(query)
+	if err != nil {
+		env.Logger.Printf("History query error: %s\n", err)
+		respondWithBadGateway(env, w, "Unable to query history")
+		return
+	}
+	respondWithHistory(env, w, models.HistoryResponse{System: system.ID, From: query.From, To: query.To, Interval: query.Interval, Series: series})
+}
+
+func APIStreamLive(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Check streaming is available
+	if env.LiveStream == nil {
+		respondWithUnavailable(env, w, "Streaming requires ENABLE_STREAM to be set to true")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		err := respondWithError(w, http.StatusInternalServerError, "Streaming is not supported")
+		if err != nil {
+			env.Logger.Println(err)
+		}
+		return
+	}
+
+	// Get requested system, streaming all systems if none is requested
+	systemID := r.URL.Query().Get("system")
+	if systemID != "" {
+		if _, ok := env.System(systemID); !ok {
+			err := respondWithError(w, http.StatusNotFound, "System not found")
+			if err != nil {
+				env.Logger.Println(err)
+			}
+			return
+		}
+	}
+
+	// Resume after the last event received if reconnecting
+	var lastID uint64
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		id, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			respondWithBadRequest(env, w, "Invalid Last-Event-ID")
+			return
+		}
+		lastID = id
+	}
+	missed, events, unsubscribe := env.LiveStream.Subscribe(lastID)
+	defer unsubscribe()
+
+	// Write each event with its own deadline instead of the server's write timeout, clearing it
+	// between events as HTTP/2 resets streams when the deadline passes even if nothing is written
+	rc := http.NewResponseController(w)
+	write := func(format string, a ...interface{}) bool {
+		err := rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err != nil && env.DebugMode {
+			env.Logger.Println(err)
+		}
+		_, err = fmt.Fprintf(w, format, a...)
+		if err != nil {
+			return false
+		}
+		flusher.Flush()
+		err = rc.SetWriteDeadline(time.Time{})
+		if err != nil && env.DebugMode {
+			env.Logger.Println(err)
+		}
+		return true
+	}
+	writeEvent := func(event models.LiveUsageEvent) bool {
+		if systemID != "" && event.System != systemID {
+			return true
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			env.Logger.Println(err)
+			return true
+		}
+		return write("id: %d\nevent: live\ndata: %s\n\n", event.ID, data)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if !write("retry: %d\n\n", streamRetry/time.Millisecond) {
+		return
+	}
+	for _, event := range missed {
+		if !writeEvent(event) {
+			return
+		}
+	}
+
+	// Send events as they're fetched, with heartbeats to keep the connection open
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// Fallen behind or shutting down, so the client reconnects and resumes
+				return
+			}
+			if !writeEvent(event) {
+				return
+			}
+		case <-heartbeat.C:
+			if !write(": heartbeat\n\n") {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func APIWebSocket(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Check the WebSocket is available
+	if env.WebSocket == nil {
+		respondWithUnavailable(env, w, "WebSocket requires ENABLE_WEBSOCKET to be set to true")
+		return
+	}
+	env.WebSocket.ServeHTTP(w, r)
 }
 
 func APIStatus(env *models.Env, w http.ResponseWriter, r *http.Request) {
-	err := respondWithJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	status := models.Status{Status: "ok", GeoAPI: env.Geo.Breaker.Status()}
+	err := respondWithJSON(w, http.StatusOK, status)
 	if err != nil {
-		env.Logger.Fatal(err)
+		env.Logger.Println(err)
 		return
 	}
 }
 
+func APIGetOpenAPI(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(openAPISpec))
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
 func APINotFound(env *models.Env, w http.ResponseWriter, r *http.Request) {
-	err := respondWithError(w, http.StatusMethodNotAllowed, "Not Found")
+	err := respondWithError(w, http.StatusNotFound, "Not Found")
 	if err != nil {
-		env.Logger.Fatal(err)
+		env.Logger.Println(err)
 		return
 	}
 }
 
 func APIMethodNotAllowed(env *models.Env, w http.ResponseWriter, r *http.Request) {
+	// Answer OPTIONS requests, which routes don't handle themselves
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET, POST, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	err := respondWithError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
 	if err != nil {
-		env.Logger.Fatal(err)
+		env.Logger.Println(err)
 		return
 	}
 }
 
-func checkErr(err error, logger *log.Logger) {
+func outputJSON(data interface{}, msg string, logger *log.Logger) {
+	dataParsed, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
-		_, file, line, ok := runtime.Caller(1)
-		_, file2, line2, ok2 := runtime.Caller(2)
-		if ok && ok2 {
-			logger.Fatalf("%s:%d -> %s:%d: %s\n", file2, line2, file, line, err.Error())
-		} else if ok {
-			logger.Fatalf("%s:%d: %s\n", file, line, err.Error())
-		} else {
-			logger.Fatal(err)
+		logger.Println(err)
+		return
+	}
+	logger.Printf("%s: \n%s", msg, string(dataParsed))
+}
+
+// requestSystem returns the system a request is for: the one named by the id route variable, or
+// the default system for routes without one. If there is no such system it responds with a 404
+// error and returns false, so the handler should return without writing anything else.
+func requestSystem(env *models.Env, w http.ResponseWriter, r *http.Request) (models.System, bool) {
+	system, ok := env.System(mux.Vars(r)["id"])
+	if !ok {
+		err := respondWithError(w, http.StatusNotFound, "System not found")
+		if err != nil {
+			env.Logger.Println(err)
 		}
 	}
+	return system, ok
 }
 
-func outputJSON(data interface{}, msg string, logger *log.Logger) {
-	dataParsed, err := json.MarshalIndent(data, "", "  ")
-	checkErr(err, logger)
-	logger.Printf("%s: \n%s", msg, string(dataParsed))
+// historyQuery returns a history query for a system from the from and to parameters, defaulting to
+// the period before now, responding with an error if history isn't available or the range is invalid.
+func historyQuery(env *models.Env, w http.ResponseWriter, r *http.Request, system models.System, period time.Duration) (models.HistoryQuery, bool) {
+	if env.History == nil {
+		respondWithUnavailable(env, w, "History requires the store or InfluxDB to be enabled")
+		return models.HistoryQuery{}, false
+	}
+
+	// Parse time range
+	query := models.HistoryQuery{SystemID: system.ID, Untagged: system.ID == env.Config.GeoSystemID}
+	var err error
+	query.To, err = parseHistoryTime(r.URL.Query().Get("to"), time.Now())
+	if err != nil {
+		respondWithBadRequest(env, w, "Invalid to time, expected RFC 3339, YYYY-MM-DD or Unix time")
+		return models.HistoryQuery{}, false
+	}
+	query.From, err = parseHistoryTime(r.URL.Query().Get("from"), query.To.Add(-period))
+	if err != nil {
+		respondWithBadRequest(env, w, "Invalid from time, expected RFC 3339, YYYY-MM-DD or Unix time")
+		return models.HistoryQuery{}, false
+	}
+	if !query.From.Before(query.To) {
+		respondWithBadRequest(env, w, "The from time must be before the to time")
+		return models.HistoryQuery{}, false
+	}
+	return query, true
+}
+
+// parseHistoryTime parses an RFC 3339 time, date or Unix time, returning defaultTime if value is empty.
+func parseHistoryTime(value string, defaultTime time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultTime.UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := backfill.ParseDate(value); err == nil {
+		return t, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+func respondWithHistory(env *models.Env, w http.ResponseWriter, history models.HistoryResponse) {
+	if history.Series == nil {
+		history.Series = []models.HistorySeries{}
+	}
+	err := respondWithJSON(w, http.StatusOK, history)
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+func respondWithBadRequest(env *models.Env, w http.ResponseWriter, message string) {
+	err := respondWithError(w, http.StatusBadRequest, message)
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+func respondWithUnavailable(env *models.Env, w http.ResponseWriter, message string) {
+	err := respondWithError(w, http.StatusServiceUnavailable, message)
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+func respondWithBadGateway(env *models.Env, w http.ResponseWriter, message string) {
+	err := respondWithError(w, http.StatusBadGateway, message)
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+// liveMeterData returns the live meter data of a system from the snapshot cache, responding if
+// it isn't modified or can't be fetched.
+func liveMeterData(env *models.Env, w http.ResponseWriter, r *http.Request, system models.System) (geo.LiveMeterData, time.Time, bool) {
+	snap, ok := snapshotData(env, w, r, snapshot.LiveKey(system.ID), func() (interface{}, error) {
+		liveData, err := system.Geo.GetLiveMeterData(system.ID)
+		return liveData, err
+	})
+	if !ok {
+		return geo.LiveMeterData{}, time.Time{}, false
+	}
+	return snap.Data.(geo.LiveMeterData), snap.FetchedAt, true
+}
+
+// periodicMeterData returns the periodic meter data of a system from the snapshot cache,
+// responding if it isn't modified or can't be fetched.
+func periodicMeterData(env *models.Env, w http.ResponseWriter, r *http.Request, system models.System) (geo.PeriodicMeterData, time.Time, bool) {
+	snap, ok := snapshotData(env, w, r, snapshot.PeriodicKey(system.ID), func() (interface{}, error) {
+		periodicData, err := system.Geo.GetPeriodicMeterData(system.ID)
+		return periodicData, err
+	})
+	if !ok {
+		return geo.PeriodicMeterData{}, time.Time{}, false
+	}
+	return snap.Data.(geo.PeriodicMeterData), snap.FetchedAt, true
+}
+
+// snapshotData returns the cached snapshot of a key, fetching it if there's none yet or a fresh
+// copy is requested with fresh=true. The caching headers are set from the snapshot, and if the
+// client already has it a 304 response is written and false is returned.
+func snapshotData(env *models.Env, w http.ResponseWriter, r *http.Request, key string, fetch func() (interface{}, error)) (snapshot.Snapshot, bool) {
+	// Get snapshot, refreshing it if needed
+	snap, ok := env.Snapshots.Get(key)
+	if !ok || r.URL.Query().Get("fresh") == "true" {
+		var err error
+		snap, err = env.Snapshots.Refresh(key, fetch)
+		if err != nil {
+			respondWithUpstreamError(env, w, err)
+			return snapshot.Snapshot{}, false
+		}
+	}
+
+	// Set caching headers
+	w.Header().Set("ETag", snap.ETag)
+	w.Header().Set("Last-Modified", snap.FetchedAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Age", strconv.Itoa(int(snap.Age()/time.Second)))
+	w.Header().Set("Cache-Control", "no-cache")
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, etag := range strings.Split(match, ",") {
+			etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+			if etag == snap.ETag || etag == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return snapshot.Snapshot{}, false
+			}
+		}
+	}
+	return snap, true
+}
+
+// respondWithKeyError writes the response for an error managing API keys.
+func respondWithKeyError(env *models.Env, w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	message := "Unable to save API keys"
+	switch err {
+	case auth.ErrInvalidKey:
+		code, message = http.StatusBadRequest, err.Error()
+	case auth.ErrKeyNotFound:
+		code, message = http.StatusNotFound, err.Error()
+	case auth.ErrKeyExists, auth.ErrKeyStatic:
+		code, message = http.StatusConflict, err.Error()
+	default:
+		env.Logger.Printf("API key error: %s\n", err)
+	}
+	err = respondWithError(w, code, message)
+	if err != nil {
+		env.Logger.Println(err)
+	}
+}
+
+// respondWithUpstreamError logs an error returned by the geo API and writes a matching
+// error response to the ResponseWriter.
+func respondWithUpstreamError(env *models.Env, w http.ResponseWriter, err error) {
+	env.Logger.Printf("geo API request failed: %s\n", err)
+	code := http.StatusBadGateway
+	message := "Upstream request failed"
+	if geoapi.IsTimeout(err) {
+		code = http.StatusGatewayTimeout
+		message = "Upstream request timed out"
+	} else if geoapi.IsTransient(err) {
+		code = http.StatusServiceUnavailable
+		message = "Upstream service unavailable"
+		if retryAt := env.Geo.Breaker.Status().RetryAt; geoapi.IsCircuitOpen(err) && retryAt > time.Now().Unix() {
+			w.Header().Set("Retry-After", strconv.FormatInt(retryAt-time.Now().Unix(), 10))
+		} else if geoapi.IsBudgetExhausted(err) {
+			message = "Upstream call budget exhausted"
+			w.Header().Set("Retry-After", strconv.Itoa(int((env.Geo.Budget.Wait()+time.Second-1)/time.Second)))
+		}
+	}
+	err = respondWithError(w, code, message)
+	if err != nil {
+		env.Logger.Println(err)
+	}
 }
 
 // respondWithError will accept a ResponseWriter, code and message and writes the code
 // and message in JSON format to the ResponseWriter.
 func respondWithError(w http.ResponseWriter, code int, message string) error {
-	return respondWithJSON(w, code, map[string]string{"error": message})
+	return respondWithJSON(w, code, models.NewErrorResponse(code, message))
 }
 
 // respondWithJSON will accept a ResponseWriter and a payload and writes the payload
@@ -0,0 +1,655 @@
+package controllers
+
+// openAPISpec is the OpenAPI 3 document describing the v1 API, served at /api/v1/openapi.json.
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "geo Energy Data API",
+    "description": "Meter data collected from geotogether smart meter displays. Paths without a system use the default system.",
+    "version": "1.0.0"
+  },
+  "servers": [{"url": "/api/v1"}],
+  "security": [{"apiKey": []}, {"bearer": []}],
+  "paths": {
+    "/systems": {
+      "get": {
+        "summary": "List the systems data is collected for",
+        "operationId": "getSystems",
+        "responses": {
+          "200": {"description": "Systems", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Systems"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/currentusage": {
+      "get": {
+        "summary": "Get current usage of the default system",
+        "operationId": "getCurrentUsage",
+        "parameters": [{"$ref": "#/components/parameters/Fresh"}, {"$ref": "#/components/parameters/IfNoneMatch"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/LiveUsage"},
+          "204": {"description": "No live usage available"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/systems/{id}/currentusage": {
+      "get": {
+        "summary": "Get current usage of a system",
+        "operationId": "getSystemCurrentUsage",
+        "parameters": [{"$ref": "#/components/parameters/SystemID"}, {"$ref": "#/components/parameters/Fresh"}, {"$ref": "#/components/parameters/IfNoneMatch"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/LiveUsage"},
+          "204": {"description": "No live usage available"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/meterreadings": {
+      "get": {
+        "summary": "Get total consumption meter readings of the default system",
+        "operationId": "getMeterReadings",
+        "parameters": [{"$ref": "#/components/parameters/Fresh"}, {"$ref": "#/components/parameters/IfNoneMatch"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/PeriodicUsage"},
+          "204": {"description": "No meter readings available"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/systems/{id}/meterreadings": {
+      "get": {
+        "summary": "Get total consumption meter readings of a system",
+        "operationId": "getSystemMeterReadings",
+        "parameters": [{"$ref": "#/components/parameters/SystemID"}, {"$ref": "#/components/parameters/Fresh"}, {"$ref": "#/components/parameters/IfNoneMatch"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/PeriodicUsage"},
+          "204": {"description": "No meter readings available"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/live": {
+      "get": {
+        "summary": "Get live power readings of the default system",
+        "operationId": "getLive",
+        "parameters": [{"$ref": "#/components/parameters/Fresh"}, {"$ref": "#/components/parameters/IfNoneMatch"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Readings"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/systems/{id}/live": {
+      "get": {
+        "summary": "Get live power readings of a system",
+        "operationId": "getSystemLive",
+        "parameters": [{"$ref": "#/components/parameters/SystemID"}, {"$ref": "#/components/parameters/Fresh"}, {"$ref": "#/components/parameters/IfNoneMatch"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Readings"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/periodic": {
+      "get": {
+        "summary": "Get consumption, bill, tariff and cost readings of the default system",
+        "operationId": "getPeriodic",
+        "parameters": [{"$ref": "#/components/parameters/Fresh"}, {"$ref": "#/components/parameters/IfNoneMatch"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Readings"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/systems/{id}/periodic": {
+      "get": {
+        "summary": "Get consumption, bill, tariff and cost readings of a system",
+        "operationId": "getSystemPeriodic",
+        "parameters": [{"$ref": "#/components/parameters/SystemID"}, {"$ref": "#/components/parameters/Fresh"}, {"$ref": "#/components/parameters/IfNoneMatch"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Readings"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/bill": {
+      "get": {
+        "summary": "Get the bill to date of the default system",
+        "operationId": "getBills",
+        "parameters": [{"$ref": "#/components/parameters/Fresh"}, {"$ref": "#/components/parameters/IfNoneMatch"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Bills"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/systems/{id}/bill": {
+      "get": {
+        "summary": "Get the bill to date of a system",
+        "operationId": "getSystemBills",
+        "parameters": [{"$ref": "#/components/parameters/SystemID"}, {"$ref": "#/components/parameters/Fresh"}, {"$ref": "#/components/parameters/IfNoneMatch"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Bills"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/tariffs": {
+      "get": {
+        "summary": "Get the tariffs of the default system",
+        "operationId": "getTariffs",
+        "parameters": [{"$ref": "#/components/parameters/Fresh"}, {"$ref": "#/components/parameters/IfNoneMatch"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Tariffs"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/systems/{id}/tariffs": {
+      "get": {
+        "summary": "Get the tariffs of a system",
+        "operationId": "getSystemTariffs",
+        "parameters": [{"$ref": "#/components/parameters/SystemID"}, {"$ref": "#/components/parameters/Fresh"}, {"$ref": "#/components/parameters/IfNoneMatch"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Tariffs"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/costs": {
+      "get": {
+        "summary": "Get the current costs of the default system",
+        "operationId": "getCosts",
+        "parameters": [{"$ref": "#/components/parameters/Duration"}, {"$ref": "#/components/parameters/Fresh"}, {"$ref": "#/components/parameters/IfNoneMatch"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Costs"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/systems/{id}/costs": {
+      "get": {
+        "summary": "Get the current costs of a system",
+        "operationId": "getSystemCosts",
+        "parameters": [{"$ref": "#/components/parameters/SystemID"}, {"$ref": "#/components/parameters/Duration"}, {"$ref": "#/components/parameters/Fresh"}, {"$ref": "#/components/parameters/IfNoneMatch"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Costs"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/history/power": {
+      "get": {
+        "summary": "Get live power of the default system over a time range",
+        "operationId": "getPowerHistory",
+        "parameters": [{"$ref": "#/components/parameters/From"}, {"$ref": "#/components/parameters/To"}, {"$ref": "#/components/parameters/Step"}, {"$ref": "#/components/parameters/Aggregate"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/History"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/systems/{id}/history/power": {
+      "get": {
+        "summary": "Get live power of a system over a time range",
+        "operationId": "getSystemPowerHistory",
+        "parameters": [{"$ref": "#/components/parameters/SystemID"}, {"$ref": "#/components/parameters/From"}, {"$ref": "#/components/parameters/To"}, {"$ref": "#/components/parameters/Step"}, {"$ref": "#/components/parameters/Aggregate"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/History"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/history/energy": {
+      "get": {
+        "summary": "Get the energy used by the default system in each interval of a time range",
+        "operationId": "getEnergyHistory",
+        "parameters": [{"$ref": "#/components/parameters/From"}, {"$ref": "#/components/parameters/To"}, {"$ref": "#/components/parameters/Interval"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/History"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/systems/{id}/history/energy": {
+      "get": {
+        "summary": "Get the energy used by a system in each interval of a time range",
+        "operationId": "getSystemEnergyHistory",
+        "parameters": [{"$ref": "#/components/parameters/SystemID"}, {"$ref": "#/components/parameters/From"}, {"$ref": "#/components/parameters/To"}, {"$ref": "#/components/parameters/Interval"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/History"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/backfill": {
+      "get": {
+        "summary": "Get backfill progress",
+        "operationId": "getBackfill",
+        "responses": {
+          "200": {"$ref": "#/components/responses/BackfillProgress"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Start a backfill of every system in the background",
+        "operationId": "startBackfill",
+        "parameters": [
+          {"name": "from", "in": "query", "required": true, "schema": {"type": "string", "format": "date"}},
+          {"name": "to", "in": "query", "description": "Defaults to yesterday", "schema": {"type": "string", "format": "date"}}
+        ],
+        "responses": {
+          "202": {"$ref": "#/components/responses/BackfillProgress"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/stream/live": {
+      "get": {
+        "summary": "Stream live usage as Server-Sent Events",
+        "operationId": "streamLive",
+        "parameters": [
+          {"name": "system", "in": "query", "description": "Only stream this system", "schema": {"type": "string"}},
+          {"name": "Last-Event-ID", "in": "header", "description": "Resume after this event", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "A stream of live events, each with data of type LiveUsageEvent", "content": {"text/event-stream": {"schema": {"type": "string"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/ws": {
+      "get": {
+        "summary": "Open a WebSocket pushing meter data and alerts on subscribed channels",
+        "operationId": "webSocket",
+        "responses": {
+          "101": {"description": "Switching to the WebSocket protocol"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/keys": {
+      "get": {
+        "summary": "List the API keys, requires the admin scope",
+        "operationId": "getKeys",
+        "responses": {
+          "200": {"description": "API keys", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/APIKeys"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Create an API key, requires the admin scope",
+        "operationId": "createKey",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["name", "scopes"],
+            "properties": {
+              "name": {"type": "string"},
+              "scopes": {"type": "array", "items": {"$ref": "#/components/schemas/Scope"}}
+            }
+          }}}
+        },
+        "responses": {
+          "201": {"$ref": "#/components/responses/NewAPIKey"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/keys/{name}/rotate": {
+      "post": {
+        "summary": "Replace an API key with a new one with the same scopes, requires the admin scope",
+        "operationId": "rotateKey",
+        "parameters": [{"$ref": "#/components/parameters/KeyName"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/NewAPIKey"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/keys/{name}": {
+      "delete": {
+        "summary": "Revoke an API key, requires the admin scope",
+        "operationId": "revokeKey",
+        "parameters": [{"$ref": "#/components/parameters/KeyName"}],
+        "responses": {
+          "204": {"description": "Revoked"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "summary": "Get this document",
+        "operationId": "getOpenAPI",
+        "security": [],
+        "responses": {
+          "200": {"description": "OpenAPI document", "content": {"application/json": {"schema": {"type": "object"}}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "apiKey": {"type": "apiKey", "in": "header", "name": "X-Api-Key"},
+      "bearer": {"type": "http", "scheme": "bearer", "bearerFormat": "JWT", "description": "Only if JWT bearer tokens are enabled"}
+    },
+    "parameters": {
+      "KeyName": {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}},
+      "SystemID": {"name": "id", "in": "path", "required": true, "schema": {"type": "string"}},
+      "Fresh": {"name": "fresh", "in": "query", "description": "Fetch the data again first, at most once every 10 seconds", "schema": {"type": "boolean"}},
+      "IfNoneMatch": {"name": "If-None-Match", "in": "header", "schema": {"type": "string"}},
+      "From": {"name": "from", "in": "query", "description": "RFC 3339 time, YYYY-MM-DD or Unix seconds", "schema": {"type": "string"}},
+      "To": {"name": "to", "in": "query", "description": "RFC 3339 time, YYYY-MM-DD or Unix seconds, defaults to now", "schema": {"type": "string"}},
+      "Step": {"name": "step", "in": "query", "description": "Duration such as 30s, 5m or 1h, or seconds", "schema": {"type": "string", "default": "5m"}},
+      "Aggregate": {"name": "agg", "in": "query", "schema": {"type": "string", "enum": ["mean", "max", "min"], "default": "mean"}},
+      "Duration": {"name": "duration", "in": "query", "description": "Only return costs for this period", "schema": {"type": "string", "enum": ["DAY", "WEEK", "MONTH"]}},
+      "Interval": {"name": "interval", "in": "query", "schema": {"type": "string", "enum": ["hour", "day", "week", "month"], "default": "day"}}
+    },
+    "responses": {
+      "Error": {"description": "Error", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "NotModified": {"description": "The data hasn't changed since the If-None-Match entity tag"},
+      "LiveUsage": {"description": "Live usage", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/LiveUsage"}}}},
+      "PeriodicUsage": {"description": "Total consumption", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PeriodicUsage"}}}},
+      "Readings": {"description": "Readings", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Readings"}}}},
+      "History": {"description": "History", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/History"}}}},
+      "NewAPIKey": {"description": "The API key, which is only ever returned here", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/APIKey"}, {"type": "object", "required": ["key"], "properties": {"key": {"type": "string"}}}]}}}},
+      "Bills": {"description": "Bills to date", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Bills"}}}},
+      "Tariffs": {"description": "Tariffs", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Tariffs"}}}},
+      "Costs": {"description": "Current costs", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Costs"}}}},
+      "BackfillProgress": {"description": "Backfill progress", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BackfillProgress"}}}}
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "required": ["error"],
+        "properties": {
+          "error": {
+            "type": "object",
+            "required": ["status", "code", "message"],
+            "properties": {
+              "status": {"type": "integer"},
+              "code": {"type": "string", "example": "not_found"},
+              "message": {"type": "string"}
+            }
+          }
+        }
+      },
+      "Scope": {"type": "string", "enum": ["read-live", "read-history", "admin"]},
+      "APIKey": {
+        "type": "object",
+        "required": ["name", "scopes", "createdAt"],
+        "properties": {
+          "name": {"type": "string"},
+          "scopes": {"type": "array", "items": {"$ref": "#/components/schemas/Scope"}},
+          "createdAt": {"type": "string", "format": "date-time"},
+          "lastUsed": {"type": "string", "format": "date-time"},
+          "static": {"type": "boolean", "description": "Set by the API_KEY environment variable, so can't be rotated or revoked"}
+        }
+      },
+      "APIKeys": {
+        "type": "object",
+        "required": ["keys"],
+        "properties": {
+          "keys": {"type": "array", "items": {"$ref": "#/components/schemas/APIKey"}}
+        }
+      },
+      "System": {
+        "type": "object",
+        "required": ["id"],
+        "properties": {
+          "id": {"type": "string"},
+          "name": {"type": "string"}
+        }
+      },
+      "Systems": {
+        "type": "object",
+        "required": ["systems"],
+        "properties": {
+          "systems": {"type": "array", "items": {"$ref": "#/components/schemas/System"}}
+        }
+      },
+      "LiveUsageData": {
+        "type": "object",
+        "required": ["watts", "lastUpdated"],
+        "properties": {
+          "watts": {"type": "number"},
+          "lastUpdated": {"type": "integer", "format": "int64"}
+        }
+      },
+      "LiveUsage": {
+        "type": "object",
+        "required": ["electricity", "gas"],
+        "properties": {
+          "electricity": {"$ref": "#/components/schemas/LiveUsageData"},
+          "gas": {"$ref": "#/components/schemas/LiveUsageData"}
+        }
+      },
+      "LiveUsageEvent": {
+        "allOf": [
+          {"$ref": "#/components/schemas/LiveUsage"},
+          {"type": "object", "required": ["system"], "properties": {"system": {"type": "string"}}}
+        ]
+      },
+      "PeriodicUsageData": {
+        "type": "object",
+        "required": ["readingTime", "totalConsumption", "unit"],
+        "properties": {
+          "readingTime": {"type": "integer", "format": "int64"},
+          "totalConsumption": {"type": "number"},
+          "unit": {"type": "string"}
+        }
+      },
+      "PeriodicUsage": {
+        "type": "object",
+        "required": ["electricity", "gas"],
+        "properties": {
+          "electricity": {"$ref": "#/components/schemas/PeriodicUsageData"},
+          "gas": {"$ref": "#/components/schemas/PeriodicUsageData"}
+        }
+      },
+      "Readings": {
+        "type": "object",
+        "required": ["system", "source", "fetchedAt"],
+        "properties": {
+          "system": {"type": "string"},
+          "systemName": {"type": "string"},
+          "source": {"type": "string", "enum": ["live", "periodic", "history"]},
+          "fetchedAt": {"type": "string", "format": "date-time"},
+          "power": {"type": "array", "items": {"$ref": "#/components/schemas/PowerReading"}},
+          "consumption": {"type": "array", "items": {"$ref": "#/components/schemas/ConsumptionReading"}},
+          "bills": {"type": "array", "items": {"$ref": "#/components/schemas/BillReading"}},
+          "tariffs": {"type": "array", "items": {"$ref": "#/components/schemas/TariffReading"}},
+          "costs": {"type": "array", "items": {"$ref": "#/components/schemas/CostReading"}}
+        }
+      },
+      "PowerReading": {
+        "type": "object",
+        "required": ["commodity", "watts", "timestamp"],
+        "properties": {
+          "commodity": {"type": "string"},
+          "watts": {"type": "number"},
+          "timestamp": {"type": "integer", "format": "int64"}
+        }
+      },
+      "ConsumptionReading": {
+        "type": "object",
+        "required": ["commodity", "value", "unit", "timestamp"],
+        "properties": {
+          "commodity": {"type": "string"},
+          "value": {"type": "number"},
+          "unit": {"type": "string", "enum": ["kWh", "m3"]},
+          "timestamp": {"type": "integer", "format": "int64"}
+        }
+      },
+      "BillReading": {
+        "type": "object",
+        "required": ["commodity", "amount", "startUtc", "validUtc", "timestamp"],
+        "properties": {
+          "commodity": {"type": "string"},
+          "amount": {"type": "number"},
+          "startUtc": {"type": "integer", "format": "int64"},
+          "validUtc": {"type": "integer", "format": "int64"},
+          "timestamp": {"type": "integer", "format": "int64"}
+        }
+      },
+      "TariffReading": {
+        "type": "object",
+        "required": ["commodity", "price", "timestamp"],
+        "properties": {
+          "commodity": {"type": "string"},
+          "price": {"type": "number"},
+          "timestamp": {"type": "integer", "format": "int64"}
+        }
+      },
+      "CostReading": {
+        "type": "object",
+        "required": ["commodity", "duration", "cost", "energy", "timestamp"],
+        "properties": {
+          "commodity": {"type": "string"},
+          "duration": {"type": "string"},
+          "cost": {"type": "number"},
+          "energy": {"type": "number"},
+          "timestamp": {"type": "integer", "format": "int64"}
+        }
+      },
+      "Bills": {
+        "type": "object",
+        "required": ["system", "fetchedAt", "bills"],
+        "properties": {
+          "system": {"type": "string"},
+          "fetchedAt": {"type": "string", "format": "date-time"},
+          "bills": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["commodity", "amount", "currency", "validFrom", "validTo", "updatedAt"],
+              "properties": {
+                "commodity": {"type": "string"},
+                "amount": {"type": "number"},
+                "currency": {"type": "string", "example": "GBP"},
+                "validFrom": {"type": "string", "format": "date-time"},
+                "validTo": {"type": "string", "format": "date-time"},
+                "updatedAt": {"type": "string", "format": "date-time"}
+              }
+            }
+          }
+        }
+      },
+      "Tariffs": {
+        "type": "object",
+        "required": ["system", "fetchedAt", "tariffs"],
+        "properties": {
+          "system": {"type": "string"},
+          "fetchedAt": {"type": "string", "format": "date-time"},
+          "tariffs": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["commodity", "price", "currency", "unit", "updatedAt"],
+              "properties": {
+                "commodity": {"type": "string"},
+                "price": {"type": "number"},
+                "currency": {"type": "string", "example": "GBP"},
+                "unit": {"type": "string", "example": "GBP/kWh"},
+                "nextPrice": {"type": "number"},
+                "nextStartTime": {"type": "string", "format": "date-time"},
+                "updatedAt": {"type": "string", "format": "date-time"}
+              }
+            }
+          }
+        }
+      },
+      "Costs": {
+        "type": "object",
+        "required": ["system", "fetchedAt", "costs"],
+        "properties": {
+          "system": {"type": "string"},
+          "fetchedAt": {"type": "string", "format": "date-time"},
+          "costs": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["commodity", "duration", "cost", "currency", "energy", "energyUnit", "updatedAt"],
+              "properties": {
+                "commodity": {"type": "string"},
+                "duration": {"type": "string", "enum": ["DAY", "WEEK", "MONTH"]},
+                "cost": {"type": "number"},
+                "currency": {"type": "string", "example": "GBP"},
+                "energy": {"type": "number"},
+                "energyUnit": {"type": "string", "example": "kWh"},
+                "updatedAt": {"type": "string", "format": "date-time"}
+              }
+            }
+          }
+        }
+      },
+      "History": {
+        "type": "object",
+        "required": ["system", "from", "to", "series"],
+        "properties": {
+          "system": {"type": "string"},
+          "from": {"type": "string", "format": "date-time"},
+          "to": {"type": "string", "format": "date-time"},
+          "step": {"type": "string"},
+          "agg": {"type": "string", "enum": ["mean", "max", "min"]},
+          "interval": {"type": "string", "enum": ["hour", "day", "week", "month"]},
+          "series": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["commodity", "unit", "points"],
+              "properties": {
+                "commodity": {"type": "string"},
+                "unit": {"type": "string"},
+                "points": {
+                  "type": "array",
+                  "items": {
+                    "type": "object",
+                    "required": ["time", "value"],
+                    "properties": {
+                      "time": {"type": "string", "format": "date-time"},
+                      "value": {"type": "number"}
+                    }
+                  }
+                }
+              }
+            }
+          }
+        }
+      },
+      "BackfillProgress": {
+        "type": "object",
+        "required": ["from", "to", "next", "daysTotal", "daysDone", "records", "running", "completed"],
+        "properties": {
+          "from": {"type": "string"},
+          "to": {"type": "string"},
+          "next": {"type": "string"},
+          "daysTotal": {"type": "integer"},
+          "daysDone": {"type": "integer"},
+          "records": {"type": "integer"},
+          "running": {"type": "boolean"},
+          "completed": {"type": "boolean"},
+          "error": {"type": "string"}
+        }
+      }
+    }
+  }
+}
+`
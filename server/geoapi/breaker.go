@@ -0,0 +1,143 @@
+package geoapi
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned instead of making a request while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("geo API circuit breaker open")
+
+// BreakerState is the state of a Breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed allows all requests
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects all requests until the cooldown has passed
+	BreakerOpen
+	// BreakerHalfOpen allows a single trial request to decide whether to close again
+	BreakerHalfOpen
+)
+
+// String returns the name of the state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerStatus describes the current state of a Breaker.
+type BreakerStatus struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	OpenedAt            int64  `json:"openedAt,omitempty"`
+	RetryAt             int64  `json:"retryAt,omitempty"`
+}
+
+// Breaker is a circuit breaker that stops requests to the geo API after repeated transient
+// failures, allowing a trial request through once the cooldown has passed. It is safe for
+// concurrent use.
+type Breaker struct {
+	// OnStateChange is called whenever the state changes, if set
+	OnStateChange func(from, to BreakerState)
+
+	threshold int
+	cooldown  time.Duration
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	trial     bool
+}
+
+// NewBreaker returns a Breaker that opens after threshold consecutive transient failures and
+// stays open for cooldown.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a request may be made, moving an open breaker to half-open once its
+// cooldown has passed. Every allowed request must be followed by a call to Record.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		b.trial = true
+		return true
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Record records the result of an allowed request. Permanent errors show the geo API is
+// responding so are counted as a success.
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if !IsTransient(err) {
+		b.failures = 0
+		b.setState(BreakerClosed)
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(BreakerOpen)
+	}
+}
+
+// Open reports whether the breaker is open and still cooling down.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == BreakerOpen && time.Since(b.openedAt) < b.cooldown
+}
+
+// Status returns the current status of the breaker.
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := BreakerStatus{State: b.state.String(), ConsecutiveFailures: b.failures}
+	if b.state != BreakerClosed {
+		status.OpenedAt = b.openedAt.Unix()
+		status.RetryAt = b.openedAt.Add(b.cooldown).Unix()
+	}
+	return status
+}
+
+// setState changes the state, calling OnStateChange if it has changed. It must be called with
+// the mutex held.
+func (b *Breaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	if b.OnStateChange != nil {
+		b.OnStateChange(from, state)
+	}
+}
+
+// IsCircuitOpen reports whether an error was caused by the circuit breaker being open.
+func IsCircuitOpen(err error) bool {
+	return errors.Is(err, ErrCircuitOpen)
+}
@@ -0,0 +1,75 @@
+package geoapi
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	errTransient = errors.New("Response Code: 503")
+	errPermanent = errors.New("Response Code: 400")
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	const cooldown = 50 * time.Millisecond
+	b := NewBreaker(3, cooldown)
+	var changes []string
+	b.OnStateChange = func(from, to BreakerState) {
+		changes = append(changes, from.String()+"->"+to.String())
+	}
+
+	// Permanent errors show the geo API is responding, so don't count towards opening
+	for _, err := range []error{errTransient, errTransient, errPermanent, errTransient, errTransient} {
+		if !b.Allow() {
+			t.Fatal("closed breaker didn't allow a request")
+		}
+		b.Record(err)
+	}
+	if status := b.Status(); status.State != "closed" || status.ConsecutiveFailures != 2 {
+		t.Fatalf("status = %+v, want closed with 2 failures", status)
+	}
+
+	// The threshold of consecutive transient failures opens it
+	b.Allow()
+	b.Record(errTransient)
+	if !b.Open() || b.Allow() {
+		t.Fatal("breaker not open after 3 consecutive failures")
+	}
+	status := b.Status()
+	if status.State != "open" || status.OpenedAt == 0 || status.RetryAt < status.OpenedAt {
+		t.Errorf("status = %+v", status)
+	}
+
+	// After the cooldown a single trial request is allowed, which reopens it if it fails
+	time.Sleep(cooldown)
+	if b.Open() {
+		t.Fatal("breaker still open after the cooldown")
+	}
+	if !b.Allow() {
+		t.Fatal("trial request not allowed")
+	}
+	if b.Allow() {
+		t.Fatal("second request allowed during the trial")
+	}
+	b.Record(errTransient)
+	if !b.Open() {
+		t.Fatal("breaker not open after the trial failed")
+	}
+
+	// A successful trial closes it again
+	time.Sleep(cooldown)
+	if !b.Allow() {
+		t.Fatal("trial request not allowed")
+	}
+	b.Record(nil)
+	if b.Open() || !b.Allow() || b.Status().ConsecutiveFailures != 0 {
+		t.Fatalf("breaker not closed after the trial succeeded: %+v", b.Status())
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("state changes = %v, want %v", changes, want)
+	}
+}
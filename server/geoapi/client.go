@@ -0,0 +1,123 @@
+package geoapi
+
+import (
+	"errors"
+	"github.com/olivercullimore/geo-energy-data-client"
+	"github.com/olivercullimore/geo-energy-data/server/ratelimit"
+)
+
+// ErrBudgetExhausted is returned instead of making a request when the call budget has been used up.
+var ErrBudgetExhausted = errors.New("geo API call budget exhausted")
+
+// Client wraps the geo API client, sharing a cached access token between requests, retrying
+// transient failures and stopping requests while the geo API is failing or the call budget has
+// been used up.
+type Client struct {
+	Tokens  *TokenManager
+	Retry   RetryPolicy
+	Breaker *Breaker
+	// Budget limits the rate of requests, shared by every client and caller such as the schedulers
+	// and API. Each attempt takes from it, including retries, but not logins made by an attempt
+	// to refresh the access token.
+	Budget *ratelimit.Bucket
+}
+
+// NewClient returns a Client for the given geo login details.
+func NewClient(user, pass string, retry RetryPolicy, breaker *Breaker, budget *ratelimit.Bucket) *Client {
+	return &Client{Tokens: NewTokenManager(user, pass), Retry: retry, Breaker: breaker, Budget: budget}
+}
+
+// NewBudget returns a call budget allowing at most perMinute requests in any minute, or nil if
+// perMinute isn't positive. Up to a quarter of them can be made at once, with the rest spread
+// over the minute.
+func NewBudget(perMinute int) *ratelimit.Bucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	burst := perMinute / 4
+	if burst < 1 {
+		burst = 1
+	}
+	rate := perMinute - burst
+	if rate < 1 {
+		rate = 1
+	}
+	return ratelimit.NewBucket(rate, burst)
+}
+
+// Login retrieves an access token, logging in again only if the cached token is due to be
+// refreshed.
+func (c *Client) Login() (string, error) {
+	var accessToken string
+	err := c.do(func() error {
+		var err error
+		accessToken, err = c.Tokens.Token()
+		return err
+	})
+	return accessToken, err
+}
+
+// GetDeviceData retrieves device data.
+func (c *Client) GetDeviceData() (geo.DeviceData, error) {
+	data, err := c.request(func(accessToken string) (interface{}, error) {
+		return geo.GetDeviceData(accessToken)
+	})
+	deviceData, _ := data.(geo.DeviceData)
+	return deviceData, err
+}
+
+// GetLiveMeterData retrieves live meter data for a system.
+func (c *Client) GetLiveMeterData(systemID string) (geo.LiveMeterData, error) {
+	data, err := c.request(func(accessToken string) (interface{}, error) {
+		return geo.GetLiveMeterData(accessToken, systemID)
+	})
+	liveData, _ := data.(geo.LiveMeterData)
+	return liveData, err
+}
+
+// GetPeriodicMeterData retrieves periodic meter data for a system.
+func (c *Client) GetPeriodicMeterData(systemID string) (geo.PeriodicMeterData, error) {
+	data, err := c.request(func(accessToken string) (interface{}, error) {
+		return geo.GetPeriodicMeterData(accessToken, systemID)
+	})
+	periodicData, _ := data.(geo.PeriodicMeterData)
+	return periodicData, err
+}
+
+// request calls fn with an access token through the retry policy and circuit breaker.
+func (c *Client) request(fn func(accessToken string) (interface{}, error)) (interface{}, error) {
+	var data interface{}
+	err := c.do(func() error {
+		var err error
+		data, err = c.Tokens.Do(fn)
+		return err
+	})
+	return data, err
+}
+
+// do calls fn through the retry policy, call budget and circuit breaker.
+func (c *Client) do(fn func() error) error {
+	return c.Retry.Do(func() error {
+		// Check the breaker first so the budget isn't used while it's open
+		if c.Breaker != nil && c.Breaker.Open() {
+			return ErrCircuitOpen
+		}
+		if ok, _ := c.Budget.Take(); !ok {
+			return ErrBudgetExhausted
+		}
+		if c.Breaker == nil {
+			return fn()
+		}
+		if !c.Breaker.Allow() {
+			return ErrCircuitOpen
+		}
+		err := fn()
+		c.Breaker.Record(err)
+		return err
+	})
+}
+
+// IsBudgetExhausted reports whether an error was caused by the call budget being used up.
+func IsBudgetExhausted(err error) bool {
+	return errors.Is(err, ErrBudgetExhausted)
+}
@@ -0,0 +1,97 @@
+package geoapi
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	// requestTimeout is the longest a single request to the geo API is allowed to take
+	requestTimeout = 20 * time.Second
+)
+
+var (
+	// ErrTimeout is returned when a request to the geo API takes longer than requestTimeout.
+	ErrTimeout = errors.New("geo API request timed out")
+	// ErrUnavailable is returned when the geo API can't be reached.
+	ErrUnavailable = errors.New("geo API unavailable")
+)
+
+// statusCodeRegex matches the response code the geo client includes in its error messages.
+var statusCodeRegex = regexp.MustCompile(`Response Code: (\d+)`)
+
+// StatusCode returns the HTTP status code reported in an error returned by the geo client,
+// or 0 if the error does not contain one.
+func StatusCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	matches := statusCodeRegex.FindStringSubmatch(err.Error())
+	if len(matches) < 2 {
+		return 0
+	}
+	code, convErr := strconv.Atoi(matches[1])
+	if convErr != nil {
+		return 0
+	}
+	return code
+}
+
+// IsUnauthorized reports whether an error returned by the geo client was caused by the
+// access token being rejected.
+func IsUnauthorized(err error) bool {
+	return StatusCode(err) == 401
+}
+
+// IsTimeout reports whether an error was caused by a request to the geo API timing out.
+func IsTimeout(err error) bool {
+	return errors.Is(err, ErrTimeout)
+}
+
+// IsTransient reports whether an error is likely to go away if the request is retried later,
+// such as the geo API being unreachable, overloaded or temporarily failing. All other errors,
+// such as invalid login details or unexpected responses, are considered permanent.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrBudgetExhausted) {
+		return true
+	}
+	switch StatusCode(err) {
+	case 408, 429, 500, 502, 503, 504:
+		return true
+	}
+	return false
+}
+
+// call runs fn, returning ErrTimeout if it takes longer than timeout and ErrUnavailable if
+// it panics, which the geo client does when a request fails before a response is received.
+func call(timeout time.Duration, fn func() (interface{}, error)) (interface{}, error) {
+	type result struct {
+		data interface{}
+		err  error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				resultCh <- result{err: fmt.Errorf("%w: %v", ErrUnavailable, r)}
+			}
+		}()
+		data, err := fn()
+		resultCh <- result{data: data, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-resultCh:
+		return r.data, r.err
+	case <-timer.C:
+		return nil, ErrTimeout
+	}
+}
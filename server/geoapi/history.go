@@ -0,0 +1,84 @@
+package geoapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// baseURL is the geo API base URL (without trailing slash)
+	baseURL = "https://api.geotogether.com"
+	// historyTimeFormat is the format of the from and to parameters of history requests
+	historyTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// HistoricTotal is the energy used and its cost for a single commodity on a single day.
+type HistoricTotal struct {
+	CommodityType string  `json:"commodityType"`
+	Year          int     `json:"year"`
+	Month         int     `json:"month"`
+	Day           int     `json:"day"`
+	EnergyAmount  float64 `json:"energyAmount"`
+	CostAmount    float64 `json:"costAmount"`
+}
+
+// HistoricDayData is the daily history of a system over a date range.
+type HistoricDayData struct {
+	ID         string          `json:"id"`
+	TotalsList []HistoricTotal `json:"totalsList"`
+}
+
+// GetHistoricDayData retrieves daily energy totals for a system between from and to. This uses
+// the undocumented history endpoint of the geo Home app, so only covers whatever history geo
+// keeps for the system.
+func (c *Client) GetHistoricDayData(systemID string, from, to time.Time) (HistoricDayData, error) {
+	data, err := c.request(func(accessToken string) (interface{}, error) {
+		return getHistoricDayData(accessToken, systemID, from, to)
+	})
+	historicData, _ := data.(HistoricDayData)
+	return historicData, err
+}
+
+func getHistoricDayData(accessToken, systemID string, from, to time.Time) (HistoricDayData, error) {
+	// Define request URL
+	query := url.Values{}
+	query.Set("from", from.UTC().Format(historyTimeFormat))
+	query.Set("to", to.UTC().Format(historyTimeFormat))
+	requestURL := fmt.Sprintf("%s/api/userapi/system/smets2-historic-day/%s?%s", baseURL, url.PathEscape(systemID), query.Encode())
+
+	// Make request
+	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return HistoricDayData{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	client := &http.Client{Timeout: requestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return HistoricDayData{}, fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	// Read response, reporting errors in the same format as the geo client
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return HistoricDayData{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(body) > 0 {
+			return HistoricDayData{}, fmt.Errorf("Response: %s Response Code: %d", body, resp.StatusCode)
+		}
+		return HistoricDayData{}, fmt.Errorf("Response Code: %d", resp.StatusCode)
+	}
+
+	var historicData HistoricDayData
+	err = json.Unmarshal(body, &historicData)
+	if err != nil {
+		return HistoricDayData{}, err
+	}
+	return historicData, nil
+}
@@ -0,0 +1,57 @@
+package geoapi
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy retries transient failures with exponential backoff and full jitter.
+type RetryPolicy struct {
+	// MaxRetries is the number of times a failed request is retried
+	MaxRetries int
+	// BaseDelay is the upper bound of the delay before the first retry, doubling with each retry
+	BaseDelay time.Duration
+	// MaxDelay is the largest upper bound of the delay before a retry
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy used by clients created with NewClient.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  time.Second,
+	MaxDelay:   30 * time.Second,
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Delay returns a random delay to wait before the given retry attempt, starting from 0.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	ceiling := p.BaseDelay
+	for i := 0; i < attempt && ceiling < p.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	if ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(ceiling) + 1))
+}
+
+// Do calls fn, retrying it while it returns a transient error until the retries are used up. It
+// doesn't retry while the circuit breaker is open or the call budget is used up.
+func (p RetryPolicy) Do(fn func() error) error {
+	err := fn()
+	for attempt := 0; attempt < p.MaxRetries && IsTransient(err) && !IsCircuitOpen(err) && !IsBudgetExhausted(err); attempt++ {
+		time.Sleep(p.Delay(attempt))
+		err = fn()
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package geoapi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	tests := []struct {
+		name  string
+		errs  []error
+		calls int
+	}{
+		{"success", []error{nil}, 1},
+		{"transient then success", []error{errTransient, ErrTimeout, nil}, 3},
+		{"transient until retries used up", []error{errTransient, errTransient, errTransient, errTransient, nil}, 4},
+		{"permanent", []error{errPermanent, nil}, 1},
+		{"permanent after transient", []error{errTransient, errPermanent, nil}, 2},
+		{"circuit open", []error{ErrCircuitOpen, nil}, 1},
+		{"budget exhausted", []error{ErrBudgetExhausted, nil}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			err := policy.Do(func() error {
+				calls++
+				return test.errs[calls-1]
+			})
+			if calls != test.calls {
+				t.Errorf("called %d times, want %d", calls, test.calls)
+			}
+			if err != test.errs[calls-1] {
+				t.Errorf("error = %v, want %v", err, test.errs[calls-1])
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	for attempt, ceiling := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		for i := 0; i < 100; i++ {
+			if delay := policy.Delay(attempt); delay < 0 || delay > ceiling {
+				t.Fatalf("delay for attempt %d = %s, want up to %s", attempt, delay, ceiling)
+			}
+		}
+	}
+	if delay := (RetryPolicy{}).Delay(0); delay != 0 {
+		t.Errorf("delay without a base delay = %s, want 0", delay)
+	}
+}
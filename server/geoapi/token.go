@@ -0,0 +1,178 @@
+package geoapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/olivercullimore/geo-energy-data-client"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTokenTTL is used when the expiry of an access token can't be determined
+	defaultTokenTTL = 30 * time.Minute
+	// maxRefreshMargin is the most time before expiry that a token will be refreshed
+	maxRefreshMargin = 5 * time.Minute
+)
+
+// TokenManager caches a geo access token and refreshes it shortly before it expires.
+// It is safe for concurrent use.
+type TokenManager struct {
+	user  string
+	pass  string
+	login func(user, pass string) (geo.AuthData, error)
+
+	mu      sync.Mutex
+	token   string
+	expiry  time.Time
+	refresh time.Time
+	// loggingIn is the login in progress, if any, which other callers wait for
+	loggingIn *tokenLogin
+}
+
+// tokenLogin is a login shared by every caller needing a new access token while it's in progress.
+type tokenLogin struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// NewTokenManager returns a TokenManager for the given geo login details.
+func NewTokenManager(user, pass string) *TokenManager {
+	return &TokenManager{user: user, pass: pass, login: geo.Login}
+}
+
+// Token returns the cached access token, logging in again if there isn't one or it is
+// due to be refreshed. Only one login is made at a time, without holding the mutex, and the
+// cached token is returned while it's being refreshed if it hasn't expired yet.
+func (tm *TokenManager) Token() (string, error) {
+	tm.mu.Lock()
+
+	// Return cached token if it isn't due to be refreshed, or is still valid while refreshing
+	now := time.Now()
+	if tm.token != "" && (now.Before(tm.refresh) || (tm.loggingIn != nil && now.Before(tm.expiry))) {
+		token := tm.token
+		tm.mu.Unlock()
+		return token, nil
+	}
+
+	// Wait for the login in progress
+	if l := tm.loggingIn; l != nil {
+		tm.mu.Unlock()
+		<-l.done
+		return l.token, l.err
+	}
+	l := &tokenLogin{done: make(chan struct{})}
+	tm.loggingIn = l
+	tm.mu.Unlock()
+
+	// Login to get a new access token
+	var expiry, refresh time.Time
+	l.token, expiry, refresh, l.err = tm.newToken()
+	tm.mu.Lock()
+	if l.err == nil {
+		tm.token = l.token
+		tm.expiry = expiry
+		tm.refresh = refresh
+	}
+	tm.loggingIn = nil
+	tm.mu.Unlock()
+	close(l.done)
+	return l.token, l.err
+}
+
+// newToken logs in to get a new access token, returning it with when it expires and should be
+// refreshed.
+func (tm *TokenManager) newToken() (string, time.Time, time.Time, error) {
+	data, err := call(requestTimeout, func() (interface{}, error) {
+		return tm.login(tm.user, tm.pass)
+	})
+	if err != nil {
+		return "", time.Time{}, time.Time{}, err
+	}
+	authData, _ := data.(geo.AuthData)
+	if authData.AccessToken == "" {
+		return "", time.Time{}, time.Time{}, errors.New("unable to retrieve an access token")
+	}
+
+	// Work out when the token should be refreshed
+	now := time.Now()
+	expiry, ok := tokenExpiry(authData.AccessToken)
+	if !ok || !expiry.After(now) {
+		expiry = now.Add(defaultTokenTTL)
+	}
+	margin := expiry.Sub(now) / 10
+	if margin > maxRefreshMargin {
+		margin = maxRefreshMargin
+	}
+	return authData.AccessToken, expiry, expiry.Add(-margin), nil
+}
+
+// Expiry returns the expiry time of the cached access token, or the zero time if there isn't one.
+func (tm *TokenManager) Expiry() time.Time {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.token == "" {
+		return time.Time{}
+	}
+	return tm.expiry
+}
+
+// Invalidate discards the cached access token if it matches the given token, so the next
+// call to Token logs in again.
+func (tm *TokenManager) Invalidate(token string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.token == token {
+		tm.token = ""
+		tm.expiry = time.Time{}
+		tm.refresh = time.Time{}
+	}
+}
+
+// Do calls fn with an access token, returning whatever fn returns. If the token is rejected
+// fn is retried once with a newly retrieved token.
+func (tm *TokenManager) Do(fn func(accessToken string) (interface{}, error)) (interface{}, error) {
+	accessToken, err := tm.Token()
+	if err != nil {
+		return nil, err
+	}
+	data, err := call(requestTimeout, func() (interface{}, error) {
+		return fn(accessToken)
+	})
+	if !IsUnauthorized(err) {
+		return data, err
+	}
+
+	// Token was rejected so discard it and retry once with a new one
+	tm.Invalidate(accessToken)
+	accessToken, err = tm.Token()
+	if err != nil {
+		return nil, err
+	}
+	return call(requestTimeout, func() (interface{}, error) {
+		return fn(accessToken)
+	})
+}
+
+// tokenExpiry reads the expiry time from the exp claim of a JWT access token.
+func tokenExpiry(accessToken string) (time.Time, bool) {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil || claims.Exp <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
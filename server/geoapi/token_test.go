@@ -0,0 +1,137 @@
+package geoapi
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/olivercullimore/geo-energy-data-client"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testToken returns an access token expiring at expiry.
+func testToken(name string, expiry time.Time) string {
+	claims := fmt.Sprintf(`{"sub":%q,"exp":%d}`, name, expiry.Unix())
+	return "header." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+}
+
+// newTestTokenManager returns a TokenManager whose logins block until release is closed, then
+// return the next of tokens, or err if it's set.
+func newTestTokenManager(tokens []string, err error) (*TokenManager, *int32, chan struct{}) {
+	var logins int32
+	release := make(chan struct{})
+	tm := NewTokenManager("user", "pass")
+	tm.login = func(user, pass string) (geo.AuthData, error) {
+		n := atomic.AddInt32(&logins, 1)
+		<-release
+		if err != nil {
+			return geo.AuthData{}, err
+		}
+		return geo.AuthData{AccessToken: tokens[n-1]}, nil
+	}
+	return tm, &logins, release
+}
+
+func TestTokenManagerSharesLogin(t *testing.T) {
+	token := testToken("first", time.Now().Add(time.Hour))
+	tm, logins, release := newTestTokenManager([]string{token}, nil)
+
+	// Callers needing a token while logging in wait for the same login
+	var wg sync.WaitGroup
+	results := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := tm.Token()
+			if err != nil {
+				t.Error(err)
+			}
+			results <- got
+		}()
+	}
+	for atomic.LoadInt32(logins) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(results)
+	for got := range results {
+		if got != token {
+			t.Errorf("token = %q, want %q", got, token)
+		}
+	}
+	if n := atomic.LoadInt32(logins); n != 1 {
+		t.Errorf("logged in %d times, want 1", n)
+	}
+}
+
+func TestTokenManagerRefreshKeepsValidToken(t *testing.T) {
+	old := testToken("old", time.Now().Add(time.Hour))
+	renewed := testToken("new", time.Now().Add(2*time.Hour))
+	tm, logins, release := newTestTokenManager([]string{old, renewed}, nil)
+	close(release)
+	_, err := tm.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make the token due to be refreshed, and block the refresh
+	tm.mu.Lock()
+	tm.refresh = time.Now().Add(-time.Second)
+	tm.mu.Unlock()
+	block := make(chan struct{})
+	login := tm.login
+	tm.login = func(user, pass string) (geo.AuthData, error) {
+		<-block
+		return login(user, pass)
+	}
+	refreshed := make(chan string)
+	go func() {
+		got, _ := tm.Token()
+		refreshed <- got
+	}()
+	for {
+		tm.mu.Lock()
+		loggingIn := tm.loggingIn != nil
+		tm.mu.Unlock()
+		if loggingIn {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Other callers get the old token without waiting, as it hasn't expired
+	got, err := tm.Token()
+	if err != nil || got != old {
+		t.Errorf("token while refreshing = %q, %v, want the old token", got, err)
+	}
+	close(block)
+	if got := <-refreshed; got != renewed {
+		t.Errorf("refreshed token = %q, want %q", got, renewed)
+	}
+	if n := atomic.LoadInt32(logins); n != 2 {
+		t.Errorf("logged in %d times, want 2", n)
+	}
+}
+
+func TestTokenManagerLoginFails(t *testing.T) {
+	loginErr := errors.New("Response Code: 503")
+	tm, logins, release := newTestTokenManager(nil, loginErr)
+	close(release)
+	for i := 1; i <= 2; i++ {
+		_, err := tm.Token()
+		if !errors.Is(err, loginErr) {
+			t.Errorf("error = %v, want %v", err, loginErr)
+		}
+		// Failed logins aren't cached, so the next caller logs in again
+		if n := atomic.LoadInt32(logins); n != int32(i) {
+			t.Errorf("logged in %d times, want %d", n, i)
+		}
+	}
+	if !tm.Expiry().IsZero() {
+		t.Error("expected no token to be cached")
+	}
+}
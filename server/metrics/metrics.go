@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// help describes each metric in the exposition output.
+var help = map[string]string{
+	"geo_live_power_watts":              "Live power usage in watts.",
+	"geo_total_consumption":             "Total consumption meter reading.",
+	"geo_bill_to_date":                  "Bill to date.",
+	"geo_active_tariff_price":           "Active tariff price.",
+	"geo_current_cost":                  "Cost of energy used in the current period.",
+	"geo_current_energy":                "Energy used in the current period.",
+	"geo_last_update_timestamp_seconds": "Unix time the meter data was last fetched.",
+}
+
+// Sample is a single gauge value.
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Exporter serves the most recently fetched meter data as Prometheus gauges. It is safe for
+// concurrent use.
+type Exporter struct {
+	mu      sync.RWMutex
+	samples map[sourceKey][]Sample
+	updated map[sourceKey]time.Time
+}
+
+// sourceKey identifies where a set of samples came from.
+type sourceKey struct {
+	system string
+	source string
+}
+
+// NewExporter returns an empty Exporter.
+func NewExporter() *Exporter {
+	return &Exporter{samples: map[sourceKey][]Sample{}, updated: map[sourceKey]time.Time{}}
+}
+
+// Update replaces the samples from a source for a system, such as live or periodic meter data.
+func (e *Exporter) Update(system, source string, samples []Sample) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := sourceKey{system: system, source: source}
+	e.samples[key] = samples
+	e.updated[key] = time.Now()
+}
+
+// ServeHTTP writes the samples in the Prometheus text exposition format.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.RLock()
+	byName := map[string][]Sample{}
+	for _, samples := range e.samples {
+		for _, sample := range samples {
+			byName[sample.Name] = append(byName[sample.Name], sample)
+		}
+	}
+	for key, updated := range e.updated {
+		sample := Sample{Name: "geo_last_update_timestamp_seconds", Labels: map[string]string{"system": key.system, "source": key.source}, Value: float64(updated.Unix())}
+		byName[sample.Name] = append(byName[sample.Name], sample)
+	}
+	e.mu.RUnlock()
+
+	// Write metrics in name order so output is stable
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		lines := make([]string, 0, len(byName[name]))
+		for _, sample := range byName[name] {
+			lines = append(lines, fmt.Sprintf("%s%s %s", name, formatLabels(sample.Labels), strconv.FormatFloat(sample.Value, 'g', -1, 64)))
+		}
+		sort.Strings(lines)
+		if help[name] != "" {
+			fmt.Fprintf(&sb, "# HELP %s %s\n", name, help[name])
+		}
+		fmt.Fprintf(&sb, "# TYPE %s gauge\n", name)
+		for _, line := range lines {
+			sb.WriteString(line)
+			sb.WriteString("\n")
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(sb.String()))
+}
+
+// formatLabels formats labels in name order, escaping their values.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, key, replacer.Replace(labels[key])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -1,10 +1,16 @@
 package middleware
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/olivercullimore/geo-energy-data/server/auth"
 	"github.com/olivercullimore/geo-energy-data/server/models"
+	"github.com/olivercullimore/geo-energy-data/server/ratelimit"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -46,31 +52,150 @@ func Logging(env *models.Env) func(http.Handler) http.Handler {
 	}
 }
 
-// CORS sets the CORS headers for the request.
-func CORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		// Call the next handler
-		next.ServeHTTP(w, r)
-	})
+const (
+	// corsAllowedMethods are the methods used by the API
+	corsAllowedMethods = "GET, POST, DELETE"
+	// corsExposedHeaders are the response headers scripts can read, besides those always allowed
+	corsExposedHeaders = "Age, Deprecation, ETag, Link, Retry-After, WWW-Authenticate"
+)
+
+// CORS sets the CORS headers of requests from origins allowed by the CORS policy, answering
+// preflight requests itself.
+func CORS(env *models.Env) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			w.Header().Add("Vary", "Origin")
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+			if origin == "" {
+				// Call the next handler
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Reject origins not allowed
+			policy := env.CORS
+			if !policy.AllowsOrigin(origin) {
+				if preflight {
+					err := respondWithError(w, http.StatusForbidden, "Origin not allowed")
+					if err != nil {
+						env.Logger.Println(err)
+					}
+					return
+				}
+				// Call the next handler
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Set CORS headers, never allowing credentials from any origin
+			if policy.AllowsAnyOrigin() {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if policy.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+			if !preflight {
+				w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+				// Call the next handler
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Answer preflight request
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+			if policy.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge/time.Second)))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// Deprecated marks responses as deprecated, linking to the same path under successorPrefix in place
+// of prefix.
+func Deprecated(prefix, successorPrefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Set deprecation headers
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", successorPrefix, strings.TrimPrefix(r.URL.Path, prefix)))
+			// Call the next handler
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
-// Auth authenticates the request.
+// keyContextKey is the context key of the API key a request was authenticated with.
+type keyContextKey struct{}
+
+// Auth authenticates the request with an API key, or a bearer token if enabled, adding the key to
+// its context.
 func Auth(env *models.Env) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
+			// Verify bearer token if given
+			authorization := r.Header.Get("Authorization")
+			if env.JWT != nil && len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+				key, err := env.JWT.Verify(strings.TrimSpace(authorization[7:]))
+				var tokenErr *auth.TokenError
+				if errors.As(err, &tokenErr) {
+					err = respondWithUnauthorized(w, env, "Invalid bearer token: "+tokenErr.Reason)
+					if err != nil {
+						env.Logger.Println(err)
+					}
+					return
+				} else if err != nil {
+					env.Logger.Println(err)
+					err = respondWithError(w, http.StatusServiceUnavailable, "Unable to verify bearer token")
+					if err != nil {
+						env.Logger.Println(err)
+					}
+					return
+				}
+				// Call the next handler
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), keyContextKey{}, key)))
+				return
+			}
 			// Get X-Api-Key header
 			var header = r.Header.Get("X-Api-Key")
 			// Validate & verify access token
 			if strings.TrimSpace(header) == "" {
-				err := respondWithError(w, http.StatusBadRequest, "Missing API key")
+				err := respondWithUnauthorized(w, env, "Missing API key")
+				if err != nil {
+					env.Logger.Println(err)
+				}
+				return
+			}
+			key, ok := env.Keys.Authenticate(header)
+			if !ok {
+				err := respondWithUnauthorized(w, env, "Invalid API key")
 				if err != nil {
 					env.Logger.Println(err)
 				}
 				return
-			} else if header != env.APIKey {
-				err := respondWithError(w, http.StatusBadRequest, "Invalid API key")
+			}
+			// Call the next handler
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), keyContextKey{}, key)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// Scope only allows requests authenticated with a key that has scope.
+func Scope(env *models.Env, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key, ok := r.Context().Value(keyContextKey{}).(auth.Key)
+			if !ok || !key.HasScope(scope) {
+				err := respondWithError(w, http.StatusForbidden, "Credentials do not have the "+scope+" scope")
 				if err != nil {
 					env.Logger.Println(err)
 				}
@@ -83,10 +208,146 @@ func Auth(env *models.Env) func(http.Handler) http.Handler {
 	}
 }
 
+// RateLimitIP limits the rate of requests from each client IP address, found by clientIP. Requests
+// on a Unix socket aren't limited by it.
+func RateLimitIP(env *models.Env) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(env, r)
+			if ip != nil && !allow(env, w, env.IPRateLimit, ip.String()) {
+				return
+			}
+			// Call the next handler
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// clientIP returns the IP address of the client making a request, or nil for a Unix socket. The
+// remote address is used unless it's a trusted proxy, when the addresses it forwarded the request
+// for are walked back from the nearest until one isn't a trusted proxy, so clients can't choose
+// their address by sending the headers themselves.
+func clientIP(env *models.Env, r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !trustedProxy(env, ip) {
+		return ip
+	}
+
+	// Walk back through the forwarded addresses, stopping at any that can't be parsed
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHop(hops[i])
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !trustedProxy(env, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// trustedProxy reports whether ip is in the trusted proxy networks.
+func trustedProxy(env *models.Env, ip net.IP) bool {
+	for _, network := range env.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the addresses requests were forwarded for, furthest first, from the
+// Forwarded headers or if there are none the X-Forwarded-For headers.
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(parts) == 2 && strings.EqualFold(parts[0], "for") {
+					hop = parts[1]
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	if len(hops) > 0 {
+		return hops
+	}
+	for _, value := range header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	return hops
+}
+
+// parseHop parses a forwarded address, which can be quoted and have a port, such as
+// "[2001:db8::1]:4711", returning nil if it isn't an IP address.
+func parseHop(hop string) net.IP {
+	hop = strings.Trim(strings.TrimSpace(hop), `"`)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+}
+
+// RateLimitKey limits the rate of requests made with each API key or bearer token subject. It
+// must be used after Auth.
+func RateLimitKey(env *models.Env) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key, _ := r.Context().Value(keyContextKey{}).(auth.Key)
+			// Bearer tokens have no hash, so keep them apart from API keys with the same name
+			id := "token:" + key.Name
+			if key.Hash != "" {
+				id = "key:" + key.Name
+			}
+			if !allow(env, w, env.KeyRateLimit, id) {
+				return
+			}
+			// Call the next handler
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// allow takes a token for id from limiter, writing a 429 response if there isn't one.
+func allow(env *models.Env, w http.ResponseWriter, limiter *ratelimit.Limiter, id string) bool {
+	ok, wait := limiter.Allow(id)
+	if ok {
+		return true
+	}
+	retryAfter := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	err := respondWithError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+	if err != nil {
+		env.Logger.Println(err)
+	}
+	return false
+}
+
+// respondWithUnauthorized writes a 401 response with message, asking for an API key or bearer
+// token.
+func respondWithUnauthorized(w http.ResponseWriter, env *models.Env, message string) error {
+	w.Header().Add("WWW-Authenticate", `APIKey realm="geo-energy-data", header="X-Api-Key"`)
+	if env.JWT != nil {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="geo-energy-data"`)
+	}
+	return respondWithError(w, http.StatusUnauthorized, message)
+}
+
 // respondWithError will accept a ResponseWriter, code and message and writes the code
 // and message in JSON format to the ResponseWriter.
 func respondWithError(w http.ResponseWriter, code int, message string) error {
-	return respondWithJSON(w, code, map[string]string{"error": message})
+	return respondWithJSON(w, code, models.NewErrorResponse(code, message))
 }
 
 // respondWithJSON will accept a ResponseWriter and a payload and writes the payload
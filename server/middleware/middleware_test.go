@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"github.com/olivercullimore/geo-energy-data/server/ratelimit"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testNetworks(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func TestClientIP(t *testing.T) {
+	env := &models.Env{TrustedProxies: testNetworks(t, "10.0.0.0/8", "2001:db8::/32")}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted proxy", "203.0.113.7:5000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"trusted proxy without header", "10.0.0.1:5000", nil, "10.0.0.1"},
+		{"X-Forwarded-For", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"spoofed X-Forwarded-For", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"192.0.2.99, 198.51.100.1"}}, "198.51.100.1"},
+		{"chained proxies", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"198.51.100.1, 10.0.0.2", "10.0.0.3"}}, "198.51.100.1"},
+		{"all trusted", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"10.0.0.2"}}, "10.0.0.2"},
+		{"invalid hop", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"198.51.100.1, junk, 10.0.0.2"}}, "10.0.0.2"},
+		{"Forwarded", "10.0.0.1:5000", http.Header{"Forwarded": {`for=192.0.2.99, for=198.51.100.1;proto=https`}}, "198.51.100.1"},
+		{"Forwarded IPv6 with port", "[2001:db8::1]:5000", http.Header{"Forwarded": {`For="[2001:db8:cafe::17]:4711"`}}, "2001:db8:cafe::17"},
+		{"Forwarded over X-Forwarded-For", "10.0.0.1:5000", http.Header{"Forwarded": {"for=198.51.100.1"}, "X-Forwarded-For": {"192.0.2.99"}}, "198.51.100.1"},
+		{"Forwarded unknown", "10.0.0.1:5000", http.Header{"Forwarded": {"for=unknown"}}, "10.0.0.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			for name, values := range test.header {
+				r.Header[name] = values
+			}
+			ip := clientIP(env, r)
+			if ip == nil || ip.String() != test.want {
+				t.Errorf("clientIP = %v, want %s", ip, test.want)
+			}
+		})
+	}
+
+	// Unix sockets have no remote address
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "@"
+	if ip := clientIP(env, r); ip != nil {
+		t.Errorf("clientIP on a Unix socket = %v, want nil", ip)
+	}
+}
+
+func TestRateLimitIPBehindProxy(t *testing.T) {
+	env := &models.Env{IPRateLimit: ratelimit.New(1, 1)}
+	handler := RateLimitIP(env)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(client string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:5000"
+		r.Header.Set("X-Forwarded-For", client)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Without trusted proxies, every client of the proxy shares its limit
+	if status := request("198.51.100.1"); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if status := request("198.51.100.2"); status != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", status)
+	}
+
+	// With the proxy trusted, each client has its own limit
+	env.IPRateLimit = ratelimit.New(1, 1)
+	env.TrustedProxies = testNetworks(t, "10.0.0.1/32")
+	for _, client := range []string{"198.51.100.1", "198.51.100.2"} {
+		if status := request(client); status != http.StatusOK {
+			t.Errorf("status for %s = %d, want 200", client, status)
+		}
+	}
+	if status := request("198.51.100.1"); status != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", status)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	policy := models.CORSPolicy{
+		AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+		AllowedHeaders: []string{"Authorization", "X-Api-Key"},
+		MaxAge:         10 * time.Minute,
+	}
+	withCredentials := policy
+	withCredentials.AllowCredentials = true
+	anyOrigin := models.CORSPolicy{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"X-Api-Key"}, AllowCredentials: true}
+	preflight := func(origin string) *http.Request {
+		r := httptest.NewRequest(http.MethodOptions, "/api/v1/live", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		return r
+	}
+	get := func(origin string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/live", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		policy  models.CORSPolicy
+		request *http.Request
+		status  int
+		// headers are the CORS headers wanted, where an empty value means the header isn't set
+		headers map[string]string
+	}{
+		{name: "preflight", policy: policy, request: preflight("https://app.example.com"), status: http.StatusNoContent, headers: map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Methods":     corsAllowedMethods,
+			"Access-Control-Allow-Headers":     "Authorization, X-Api-Key",
+			"Access-Control-Max-Age":           "600",
+			"Access-Control-Allow-Credentials": "",
+			"Access-Control-Expose-Headers":    "",
+		}},
+		{name: "preflight from a wildcard origin", policy: policy, request: preflight("https://dashboard.example.org"), status: http.StatusNoContent, headers: map[string]string{
+			"Access-Control-Allow-Origin": "https://dashboard.example.org",
+		}},
+		{name: "preflight from a disallowed origin", policy: policy, request: preflight("https://evil.example.com"), status: http.StatusForbidden, headers: map[string]string{
+			"Access-Control-Allow-Origin":  "",
+			"Access-Control-Allow-Methods": "",
+		}},
+		{name: "request", policy: policy, request: get("https://app.example.com"), status: http.StatusOK, headers: map[string]string{
+			"Access-Control-Allow-Origin":   "https://app.example.com",
+			"Access-Control-Expose-Headers": corsExposedHeaders,
+			"Access-Control-Allow-Methods":  "",
+		}},
+		{name: "request from a disallowed origin", policy: policy, request: get("https://evil.example.com"), status: http.StatusOK, headers: map[string]string{
+			"Access-Control-Allow-Origin":   "",
+			"Access-Control-Expose-Headers": "",
+		}},
+		{name: "request without an origin", policy: policy, request: get(""), status: http.StatusOK, headers: map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{name: "credentials", policy: withCredentials, request: get("https://app.example.com"), status: http.StatusOK, headers: map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Credentials": "true",
+		}},
+		{name: "preflight with credentials", policy: withCredentials, request: preflight("https://app.example.com"), status: http.StatusNoContent, headers: map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Credentials": "true",
+		}},
+		{name: "credentials from any origin", policy: anyOrigin, request: get("https://evil.example.com"), status: http.StatusOK, headers: map[string]string{
+			"Access-Control-Allow-Origin":      "*",
+			"Access-Control-Allow-Credentials": "",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &models.Env{Logger: log.New(ioutil.Discard, "", 0), CORS: tt.policy}
+			handler := CORS(env)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, tt.request)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			for name, want := range tt.headers {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			if vary := w.Header().Values("Vary"); len(vary) == 0 || vary[0] != "Origin" {
+				t.Errorf("Vary = %q, want Origin first", vary)
+			}
+		})
+	}
+}
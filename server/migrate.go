@@ -0,0 +1,59 @@
+package server
+
+import (
+	"flag"
+	"github.com/olivercullimore/geo-energy-data/server/backfill"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"os"
+	"path/filepath"
+)
+
+// Migrate runs the migrate command, rewriting the points in InfluxDB for a date range with the
+// tags of the schema set by SCHEMA_VERSION. Running it again resumes an interrupted migration. The
+// daemon must be stopped while migrating, as points it writes to a day being migrated may be lost.
+func Migrate(args []string) {
+	// Parse arguments
+	today := backfill.Today()
+	yesterday := backfill.Yesterday().Format(backfill.DateFormat)
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fromStr := flags.String("from", "", "first day to migrate in YYYY-MM-DD format")
+	toStr := flags.String("to", yesterday, "last day to migrate in YYYY-MM-DD format")
+	force := flags.Bool("force", false, "allow migrating the current day")
+	_ = flags.Parse(args)
+
+	// Set up from environment variables and config
+	a := setup()
+	env := a.env
+	if a.influxDB == nil {
+		env.Logger.Fatalf("Migration requires InfluxDB to be enabled\n")
+	}
+	if env.Schema.Version < models.SchemaV2 {
+		env.Logger.Fatalf("Set SCHEMA_VERSION to %d before migrating\n", models.SchemaV2)
+	}
+
+	// Validate date range
+	from, err := backfill.ParseDate(*fromStr)
+	if err != nil {
+		env.Logger.Fatalf("Invalid from date %q, expected YYYY-MM-DD\n", *fromStr)
+	}
+	to, err := backfill.ParseDate(*toStr)
+	if err != nil {
+		env.Logger.Fatalf("Invalid to date %q, expected YYYY-MM-DD\n", *toStr)
+	}
+	if from.After(to) {
+		env.Logger.Fatalf("Migration from date must not be after the to date\n")
+	}
+	if !to.Before(today) && !*force {
+		env.Logger.Fatalf("Refusing to migrate the current day while points may still be written to it, use -force to migrate it anyway\n")
+	}
+
+	// Run migration
+	env.Logger.Printf("Migrating points from %s to %s to schema version %d\n", *fromStr, *toStr, env.Schema.Version)
+	migrated, err := a.influxDB.Migrate(from, to, filepath.Join(a.configDir, "migration.lp"))
+	a.influxDB.Close()
+	if err != nil {
+		env.Logger.Printf("Migration stopped after %d points: %s\n", migrated, err)
+		os.Exit(1)
+	}
+	env.Logger.Printf("Finished migrating %d points\n", migrated)
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"github.com/olivercullimore/geo-energy-data/server/auth"
+	"net/http"
+	"strings"
+)
+
+// ErrorResponse is the body of every API error response.
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// APIError describes why a request failed. Code is the HTTP status text in snake case, such as
+// not_found, for clients to match on.
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewErrorResponse returns the error response for an HTTP status code and message.
+func NewErrorResponse(status int, message string) ErrorResponse {
+	code := strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	return ErrorResponse{Error: APIError{Status: status, Code: code, Message: message}}
+}
+
+// SystemsResponse is the response listing the systems data is collected for.
+type SystemsResponse struct {
+	Systems []System `json:"systems"`
+}
+
+// APIKeysResponse is the response listing the API keys.
+type APIKeysResponse struct {
+	Keys []auth.KeyInfo `json:"keys"`
+}
+
+// APIKeyRequest is the request to create an API key.
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// NewAPIKeyResponse is the response to creating or rotating an API key, the only time the key is
+// returned.
+type NewAPIKeyResponse struct {
+	auth.KeyInfo
+	Key string `json:"key"`
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"github.com/olivercullimore/geo-energy-data-client"
+	"strings"
+	"time"
+)
+
+const (
+	// DurationDay, DurationWeek and DurationMonth are the periods current costs are given for
+	DurationDay   = "DAY"
+	DurationWeek  = "WEEK"
+	DurationMonth = "MONTH"
+)
+
+// Bill is the bill to date of a commodity, valid for the billing period from ValidFrom to ValidTo.
+type Bill struct {
+	Commodity string    `json:"commodity"`
+	Amount    float64   `json:"amount"`
+	Currency  string    `json:"currency"`
+	ValidFrom time.Time `json:"validFrom"`
+	ValidTo   time.Time `json:"validTo"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// BillsResponse is the response listing the bill to date of each commodity.
+type BillsResponse struct {
+	System    string    `json:"system"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Bills     []Bill    `json:"bills"`
+}
+
+// Tariff is the active tariff of a commodity, and the next tariff if it's known.
+type Tariff struct {
+	Commodity     string     `json:"commodity"`
+	Price         float64    `json:"price"`
+	Currency      string     `json:"currency"`
+	Unit          string     `json:"unit"`
+	NextPrice     *float64   `json:"nextPrice,omitempty"`
+	NextStartTime *time.Time `json:"nextStartTime,omitempty"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+// TariffsResponse is the response listing the tariff of each commodity.
+type TariffsResponse struct {
+	System    string    `json:"system"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Tariffs   []Tariff  `json:"tariffs"`
+}
+
+// Cost is the cost of and energy used by a commodity so far in the current day, week or month.
+type Cost struct {
+	Commodity  string    `json:"commodity"`
+	Duration   string    `json:"duration"`
+	Cost       float64   `json:"cost"`
+	Currency   string    `json:"currency"`
+	Energy     float64   `json:"energy"`
+	EnergyUnit string    `json:"energyUnit"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// CostsResponse is the response listing the current costs of each commodity.
+type CostsResponse struct {
+	System    string    `json:"system"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Costs     []Cost    `json:"costs"`
+}
+
+// NewBillsResponse returns the available bills to date in periodic meter data.
+func NewBillsResponse(system System, periodicData geo.PeriodicMeterData, currency string, fetchedAt time.Time) BillsResponse {
+	response := BillsResponse{System: system.ID, FetchedAt: fetchedAt, Bills: []Bill{}}
+	if periodicData.BillToDateTimestamp > 0 {
+		for _, item := range periodicData.BillToDateList {
+			if item.ValueAvailable {
+				response.Bills = append(response.Bills, Bill{
+					Commodity: item.CommodityType,
+					Amount:    item.BillToDate,
+					Currency:  currency,
+					ValidFrom: time.Unix(item.StartUTC, 0).UTC(),
+					ValidTo:   time.Unix(item.ValidUTC, 0).UTC(),
+					UpdatedAt: time.Unix(periodicData.BillToDateTimestamp, 0).UTC(),
+				})
+			}
+		}
+	}
+	return response
+}
+
+// NewTariffsResponse returns the available active tariffs in periodic meter data.
+func NewTariffsResponse(system System, periodicData geo.PeriodicMeterData, currency string, fetchedAt time.Time) TariffsResponse {
+	response := TariffsResponse{System: system.ID, FetchedAt: fetchedAt, Tariffs: []Tariff{}}
+	if periodicData.ActiveTariffTimestamp > 0 {
+		for _, item := range periodicData.ActiveTariffList {
+			if item.ValueAvailable {
+				tariff := Tariff{
+					Commodity: item.CommodityType,
+					Price:     item.ActiveTariffPrice,
+					Currency:  currency,
+					Unit:      currency + "/kWh",
+					UpdatedAt: time.Unix(periodicData.ActiveTariffTimestamp, 0).UTC(),
+				}
+				if item.NextPriceAvailable {
+					nextPrice := item.NextTariffPrice
+					nextStartTime := time.Unix(int64(item.NextTariffStartTime), 0).UTC()
+					tariff.NextPrice = &nextPrice
+					tariff.NextStartTime = &nextStartTime
+				}
+				response.Tariffs = append(response.Tariffs, tariff)
+			}
+		}
+	}
+	return response
+}
+
+// NewCostsResponse returns the current costs in periodic meter data, only including those for
+// duration if it's set.
+func NewCostsResponse(system System, periodicData geo.PeriodicMeterData, currency, duration string, fetchedAt time.Time) CostsResponse {
+	response := CostsResponse{System: system.ID, FetchedAt: fetchedAt, Costs: []Cost{}}
+	add := func(costs []geo.PeriodicMeterDataCurrentCost, timestamp int64) {
+		if timestamp <= 0 {
+			return
+		}
+		for _, item := range costs {
+			if duration != "" && !strings.EqualFold(item.Duration, duration) {
+				continue
+			}
+			response.Costs = append(response.Costs, Cost{
+				Commodity:  item.CommodityType,
+				Duration:   item.Duration,
+				Cost:       item.CostAmount,
+				Currency:   currency,
+				Energy:     item.EnergyAmount,
+				EnergyUnit: "kWh",
+				UpdatedAt:  time.Unix(timestamp, 0).UTC(),
+			})
+		}
+	}
+	add(periodicData.CurrentCostsElec, periodicData.CurrentCostsElecTimestamp)
+	add(periodicData.CurrentCostsGas, periodicData.CurrentCostsGasTimestamp)
+	return response
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"github.com/olivercullimore/geo-energy-data-client"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	billingSystem    = System{ID: "system-1", Name: "Test"}
+	billingFetchedAt = time.Date(2021, 2, 3, 10, 0, 0, 0, time.UTC)
+)
+
+func TestNewBillsResponse(t *testing.T) {
+	periodicData := geo.PeriodicMeterData{
+		BillToDateTimestamp: 1612345600,
+		BillToDateList: []geo.PeriodicMeterDataBillToDate{
+			{CommodityType: "ELECTRICITY", BillToDate: 12.34, StartUTC: 1612137600, ValidUTC: 1614556799, ValueAvailable: true},
+			{CommodityType: "GAS_ENERGY", BillToDate: 5, ValueAvailable: false},
+		},
+	}
+	got := NewBillsResponse(billingSystem, periodicData, "GBP", billingFetchedAt)
+	want := BillsResponse{System: "system-1", FetchedAt: billingFetchedAt, Bills: []Bill{{
+		Commodity: "ELECTRICITY",
+		Amount:    12.34,
+		Currency:  "GBP",
+		ValidFrom: time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC),
+		ValidTo:   time.Date(2021, 2, 28, 23, 59, 59, 0, time.UTC),
+		UpdatedAt: time.Date(2021, 2, 3, 9, 46, 40, 0, time.UTC),
+	}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bills = %+v, want %+v", got, want)
+	}
+
+	// Bills without a timestamp haven't been received, so none are returned
+	periodicData.BillToDateTimestamp = 0
+	got = NewBillsResponse(billingSystem, periodicData, "GBP", billingFetchedAt)
+	if got.Bills == nil || len(got.Bills) != 0 {
+		t.Errorf("bills = %#v, want an empty list", got.Bills)
+	}
+}
+
+func TestNewTariffsResponse(t *testing.T) {
+	periodicData := geo.PeriodicMeterData{
+		ActiveTariffTimestamp: 1612345600,
+		ActiveTariffList: []geo.PeriodicMeterDataActiveTariff{
+			{CommodityType: "ELECTRICITY", ActiveTariffPrice: 0.1523, ValueAvailable: true},
+			{CommodityType: "GAS_ENERGY", ActiveTariffPrice: 0.03, NextTariffPrice: 0.04, NextTariffStartTime: 1614556800, NextPriceAvailable: true, ValueAvailable: true},
+			{CommodityType: "WATER", ValueAvailable: false},
+		},
+	}
+	got := NewTariffsResponse(billingSystem, periodicData, "EUR", billingFetchedAt)
+	updatedAt := time.Date(2021, 2, 3, 9, 46, 40, 0, time.UTC)
+	nextPrice := 0.04
+	nextStartTime := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	want := TariffsResponse{System: "system-1", FetchedAt: billingFetchedAt, Tariffs: []Tariff{
+		{Commodity: "ELECTRICITY", Price: 0.1523, Currency: "EUR", Unit: "EUR/kWh", UpdatedAt: updatedAt},
+		{Commodity: "GAS_ENERGY", Price: 0.03, Currency: "EUR", Unit: "EUR/kWh", NextPrice: &nextPrice, NextStartTime: &nextStartTime, UpdatedAt: updatedAt},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tariffs = %+v, want %+v", got, want)
+	}
+
+	periodicData.ActiveTariffTimestamp = 0
+	got = NewTariffsResponse(billingSystem, periodicData, "EUR", billingFetchedAt)
+	if got.Tariffs == nil || len(got.Tariffs) != 0 {
+		t.Errorf("tariffs = %#v, want an empty list", got.Tariffs)
+	}
+}
+
+func TestNewCostsResponse(t *testing.T) {
+	periodicData := geo.PeriodicMeterData{
+		CurrentCostsElecTimestamp: 1612345600,
+		CurrentCostsElec: []geo.PeriodicMeterDataCurrentCost{
+			{CommodityType: "ELECTRICITY", Duration: "DAY", CostAmount: 1.2, EnergyAmount: 8.5},
+			{CommodityType: "ELECTRICITY", Duration: "WEEK", CostAmount: 7.5, EnergyAmount: 52},
+		},
+		CurrentCostsGasTimestamp: 1612345000,
+		CurrentCostsGas: []geo.PeriodicMeterDataCurrentCost{
+			{CommodityType: "GAS_ENERGY", Duration: "DAY", CostAmount: 0.8, EnergyAmount: 20},
+		},
+	}
+	elecUpdatedAt := time.Date(2021, 2, 3, 9, 46, 40, 0, time.UTC)
+	gasUpdatedAt := time.Date(2021, 2, 3, 9, 36, 40, 0, time.UTC)
+	elecDay := Cost{Commodity: "ELECTRICITY", Duration: "DAY", Cost: 1.2, Currency: "GBP", Energy: 8.5, EnergyUnit: "kWh", UpdatedAt: elecUpdatedAt}
+	elecWeek := Cost{Commodity: "ELECTRICITY", Duration: "WEEK", Cost: 7.5, Currency: "GBP", Energy: 52, EnergyUnit: "kWh", UpdatedAt: elecUpdatedAt}
+	gasDay := Cost{Commodity: "GAS_ENERGY", Duration: "DAY", Cost: 0.8, Currency: "GBP", Energy: 20, EnergyUnit: "kWh", UpdatedAt: gasUpdatedAt}
+
+	tests := []struct {
+		name     string
+		duration string
+		// gasTimestamp replaces the timestamp of the gas costs if it's set
+		gasTimestamp int64
+		want         []Cost
+	}{
+		{name: "all", want: []Cost{elecDay, elecWeek, gasDay}},
+		{name: "day", duration: DurationDay, want: []Cost{elecDay, gasDay}},
+		{name: "lowercase", duration: "week", want: []Cost{elecWeek}},
+		{name: "none", duration: DurationMonth, want: []Cost{}},
+		{name: "gas not received", gasTimestamp: -1, want: []Cost{elecDay, elecWeek}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := periodicData
+			if tt.gasTimestamp != 0 {
+				data.CurrentCostsGasTimestamp = tt.gasTimestamp
+			}
+			got := NewCostsResponse(billingSystem, data, "GBP", tt.duration, billingFetchedAt)
+			want := CostsResponse{System: "system-1", FetchedAt: billingFetchedAt, Costs: tt.want}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("costs = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	// AggregateMean, AggregateMax and AggregateMin are how power is aggregated over each step
+	AggregateMean = "mean"
+	AggregateMax  = "max"
+	AggregateMin  = "min"
+
+	// IntervalHour, IntervalDay, IntervalWeek and IntervalMonth are the intervals energy use is
+	// totalled over. Intervals start at midnight UTC, with weeks starting on Monday.
+	IntervalHour  = "hour"
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+// History queries recorded meter data.
+type History interface {
+	// Power returns live power for each commodity, aggregated over each step.
+	Power(query HistoryQuery) ([]HistorySeries, error)
+	// Energy returns the energy used by each commodity in each interval.
+	Energy(query HistoryQuery) ([]HistorySeries, error)
+}
+
+// HistoryQuery selects the recorded meter data of a system between From and To.
+type HistoryQuery struct {
+	SystemID string
+	// Untagged includes points written before points were tagged with their system
+	Untagged  bool
+	From      time.Time
+	To        time.Time
+	Step      time.Duration
+	Aggregate string
+	Interval  string
+}
+
+// HistoryPoint is a single value in a series, timestamped at the start of its step or interval.
+type HistoryPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// HistorySeries is the history of a single commodity.
+type HistorySeries struct {
+	Commodity string         `json:"commodity"`
+	Unit      string         `json:"unit"`
+	Points    []HistoryPoint `json:"points"`
+}
+
+// HistoryResponse is the response to a history request.
+type HistoryResponse struct {
+	System    string          `json:"system"`
+	From      time.Time       `json:"from"`
+	To        time.Time       `json:"to"`
+	Step      string          `json:"step,omitempty"`
+	Aggregate string          `json:"agg,omitempty"`
+	Interval  string          `json:"interval,omitempty"`
+	Series    []HistorySeries `json:"series"`
+}
+
+// IntervalStart returns the start of the interval t is in.
+func IntervalStart(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	switch interval {
+	case IntervalHour:
+		return t.Truncate(time.Hour)
+	case IntervalWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case IntervalMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// NextInterval returns the start of the interval after the one starting at start.
+func NextInterval(start time.Time, interval string) time.Time {
+	switch interval {
+	case IntervalHour:
+		return start.Add(time.Hour)
+	case IntervalWeek:
+		return start.AddDate(0, 0, 7)
+	case IntervalMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// PreviousInterval returns the start of the interval before the one starting at start.
+func PreviousInterval(start time.Time, interval string) time.Time {
+	switch interval {
+	case IntervalHour:
+		return start.Add(-time.Hour)
+	case IntervalWeek:
+		return start.AddDate(0, 0, -7)
+	case IntervalMonth:
+		return start.AddDate(0, -1, 0)
+	default:
+		return start.AddDate(0, 0, -1)
+	}
+}
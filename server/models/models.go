@@ -1,12 +1,45 @@
 package models
 
 import (
+	"github.com/olivercullimore/geo-energy-data/server/auth"
+	"github.com/olivercullimore/geo-energy-data/server/geoapi"
+	"github.com/olivercullimore/geo-energy-data/server/metrics"
+	"github.com/olivercullimore/geo-energy-data/server/ratelimit"
+	"github.com/olivercullimore/geo-energy-data/server/snapshot"
 	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	GeoSystemID    string
 	CalorificValue float64
+	Accounts       []AccountConfig `json:",omitempty"`
+	APIKeys        []auth.Key      `json:",omitempty"`
+}
+
+// AccountConfig is a geo account and the systems to collect data for with it. The password is
+// only saved for accounts other than the one set by GEO_USER and GEO_PASS.
+type AccountConfig struct {
+	User    string
+	Pass    string `json:",omitempty"`
+	Systems []SystemConfig
+}
+
+// SystemConfig is a geo system and its optional friendly name.
+type SystemConfig struct {
+	ID   string
+	Name string `json:",omitempty"`
+}
+
+// System is a geo system data is collected for, with the client of the account it belongs to.
+type System struct {
+	ID   string         `json:"id"`
+	Name string         `json:"name,omitempty"`
+	Geo  *geoapi.Client `json:"-"`
 }
 
 type Env struct {
@@ -14,10 +47,111 @@ type Env struct {
 	Logger         *log.Logger
 	GeoUser        string
 	GeoPass        string
+	Geo            *geoapi.Client
+	Systems        []System
+	Snapshots      *snapshot.Cache
+	Backfill       Backfiller
+	History        History
+	LiveStream     LiveStream
+	WebSocket      http.Handler
+	Metrics        *metrics.Exporter
+	Schema         Schema
 	EnableAPI      bool
 	EnableInfluxDB bool
 	DebugMode      bool
-	APIKey         string
+	Keys           *auth.Store
+	JWT            *auth.JWTVerifier
+	KeyRateLimit   *ratelimit.Limiter
+	IPRateLimit    *ratelimit.Limiter
+	// TrustedProxies are the networks of reverse proxies whose X-Forwarded-For and Forwarded
+	// headers are believed for the client IP address
+	TrustedProxies []*net.IPNet
+	CORS           CORSPolicy
+}
+
+// System returns the system with id, or the default system if id is empty.
+func (e *Env) System(id string) (System, bool) {
+	if id == "" {
+		id = e.Config.GeoSystemID
+	}
+	for _, system := range e.Systems {
+		if system.ID == id {
+			return system, true
+		}
+	}
+	return System{}, false
+}
+
+// CORSPolicy is the cross-origin requests the API allows from browsers.
+type CORSPolicy struct {
+	// AllowedOrigins are the origins allowed, which can include * wildcards such as
+	// https://*.example.com, or be * to allow any origin
+	AllowedOrigins   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers can cache preflight responses for
+	MaxAge time.Duration
+}
+
+// AllowsAnyOrigin reports whether requests from any origin are allowed.
+func (p CORSPolicy) AllowsAnyOrigin() bool {
+	for _, pattern := range p.AllowedOrigins {
+		if pattern == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsOrigin reports whether requests from origin are allowed.
+func (p CORSPolicy) AllowsOrigin(origin string) bool {
+	if p.AllowsAnyOrigin() {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, pattern := range p.AllowedOrigins {
+		if ok, _ := path.Match(strings.ToLower(pattern), origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LiveStream fans live usage out to subscribers as it's fetched.
+type LiveStream interface {
+	// Subscribe returns the buffered events after lastID and a channel of new events, which is
+	// closed if the subscriber falls behind. The returned function must be called to unsubscribe.
+	Subscribe(lastID uint64) ([]LiveUsageEvent, <-chan LiveUsageEvent, func())
+}
+
+// LiveUsageEvent is the live usage of a system, identified so a stream can be resumed after it.
+type LiveUsageEvent struct {
+	ID     uint64 `json:"-"`
+	System string `json:"system"`
+	LiveUsage
+}
+
+// Backfiller backfills historical data.
+type Backfiller interface {
+	Start(from, to time.Time) error
+	Run(from, to time.Time) error
+	Progress() BackfillProgress
+	// Stop stops a backfill started in the background after the day it's on, so it can be resumed
+	Stop()
+}
+
+// BackfillProgress describes the progress of a backfill. It is saved after each day so an
+// interrupted backfill of the same date range carries on where it stopped.
+type BackfillProgress struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Next      string `json:"next"`
+	DaysTotal int    `json:"daysTotal"`
+	DaysDone  int    `json:"daysDone"`
+	Records   int    `json:"records"`
+	Running   bool   `json:"running"`
+	Completed bool   `json:"completed"`
+	Error     string `json:"error,omitempty"`
 }
 
 type LiveUsageData struct {
@@ -38,3 +172,16 @@ type PeriodicUsage struct {
 	Electricity PeriodicUsageData `json:"electricity"`
 	Gas         PeriodicUsageData `json:"gas"`
 }
+
+// Alert is an event clients may want to be told about, such as the geo API becoming unavailable.
+type Alert struct {
+	Type    string `json:"type"`
+	System  string `json:"system,omitempty"`
+	Message string `json:"message"`
+	Time    int64  `json:"time"`
+}
+
+type Status struct {
+	Status string               `json:"status"`
+	GeoAPI geoapi.BreakerStatus `json:"geoApi"`
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MeasurementMeterData, MeasurementBill, MeasurementTariff and MeasurementCurrentCosts are
+	// the measurements meter data is written to
+	MeasurementMeterData    = "meterdata"
+	MeasurementBill         = "meterdata_bill"
+	MeasurementTariff       = "meterdata_tariff"
+	MeasurementCurrentCosts = "meterdata_currentcosts"
+)
+
+var (
+	// measurementEscaper escapes measurement names in line protocol
+	measurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, "\n", `\n`)
+	// keyEscaper escapes tag keys, tag values and field keys in line protocol
+	keyEscaper = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+)
+
+// Point is a single time series data point.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]float64
+	Timestamp   int64
+}
+
+// LineProtocol encodes the point as a line protocol record with second precision. Tags and fields
+// are written in key order so the same point always encodes the same way.
+func (p Point) LineProtocol() string {
+	var sb strings.Builder
+	sb.WriteString(measurementEscaper.Replace(p.Measurement))
+
+	// Write tags, skipping empty values as line protocol doesn't allow them
+	for _, key := range sortedKeys(p.Tags) {
+		if p.Tags[key] == "" {
+			continue
+		}
+		sb.WriteByte(',')
+		sb.WriteString(keyEscaper.Replace(key))
+		sb.WriteByte('=')
+		sb.WriteString(keyEscaper.Replace(p.Tags[key]))
+	}
+
+	// Write fields
+	fieldKeys := make([]string, 0, len(p.Fields))
+	for key := range p.Fields {
+		fieldKeys = append(fieldKeys, key)
+	}
+	sort.Strings(fieldKeys)
+	for i, key := range fieldKeys {
+		if i == 0 {
+			sb.WriteByte(' ')
+		} else {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(keyEscaper.Replace(key))
+		sb.WriteByte('=')
+		sb.WriteString(strconv.FormatFloat(p.Fields[key], 'f', -1, 64))
+	}
+
+	// Write timestamp
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatInt(p.Timestamp, 10))
+	return sb.String()
+}
+
+// Points converts readings to the points written for them with schema.
+func (r Readings) Points(schema Schema) []Point {
+	// Build points with the original schema
+	var points []Point
+	for _, item := range r.Power {
+		points = append(points, Point{
+			Measurement: MeasurementMeterData,
+			Tags:        map[string]string{"source": r.Source, "unit": "watts", "type": item.Commodity},
+			Fields:      map[string]float64{"val": item.Watts},
+			Timestamp:   item.Timestamp,
+		})
+	}
+	for _, item := range r.Consumption {
+		unit := item.Unit
+		if unit == "kWh" {
+			unit = "watts"
+		}
+		points = append(points, Point{
+			Measurement: MeasurementMeterData,
+			Tags:        map[string]string{"source": r.Source, "unit": unit, "type": item.Commodity},
+			Fields:      map[string]float64{"val": item.Value},
+			Timestamp:   item.Timestamp,
+		})
+	}
+	for _, item := range r.Bills {
+		points = append(points, Point{
+			Measurement: MeasurementBill,
+			Tags: map[string]string{
+				"source":   r.Source,
+				"type":     item.Commodity,
+				"validutc": strconv.FormatInt(item.ValidUTC, 10),
+				"startutc": strconv.FormatInt(item.StartUTC, 10),
+			},
+			Fields:    map[string]float64{"val": item.Amount},
+			Timestamp: item.Timestamp,
+		})
+	}
+	for _, item := range r.Tariffs {
+		points = append(points, Point{
+			Measurement: MeasurementTariff,
+			Tags:        map[string]string{"source": r.Source, "type": item.Commodity},
+			Fields:      map[string]float64{"val": item.Price},
+			Timestamp:   item.Timestamp,
+		})
+	}
+	for _, item := range r.Costs {
+		points = append(points, Point{
+			Measurement: MeasurementCurrentCosts,
+			Tags:        map[string]string{"source": r.Source, "type": item.Commodity, "duration": item.Duration, "subtype": "cost"},
+			Fields:      map[string]float64{"val": item.Cost},
+			Timestamp:   item.Timestamp,
+		})
+		points = append(points, Point{
+			Measurement: MeasurementCurrentCosts,
+			Tags:        map[string]string{"source": r.Source, "type": item.Commodity, "duration": item.Duration, "subtype": "energy"},
+			Fields:      map[string]float64{"val": item.Energy},
+			Timestamp:   item.Timestamp,
+		})
+	}
+
+	// Tag points with the system they are for and upgrade them to the schema being written
+	for i := range points {
+		points[i].Tags["system"] = r.System
+		points[i].Tags["system_name"] = r.SystemName
+		points[i] = schema.Upgrade(points[i])
+	}
+	return points
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
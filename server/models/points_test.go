@@ -0,0 +1,113 @@
+package models
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// liveReadings and periodicReadings are for a system with a name that needs escaping in line
+// protocol.
+var (
+	liveReadings = Readings{
+		System:     "system-1",
+		SystemName: "Flat 2, Block=A",
+		Source:     SourceLive,
+		Power: []PowerReading{
+			{Commodity: "ELECTRICITY", Watts: 350, Timestamp: 1612345678},
+			{Commodity: "GAS_ENERGY", Watts: 0, Timestamp: 1612345678},
+		},
+	}
+	periodicReadings = Readings{
+		System:     "system-1",
+		SystemName: "Flat 2, Block=A",
+		Source:     SourcePeriodic,
+		Consumption: []ConsumptionReading{
+			{Commodity: "ELECTRICITY", Value: 1234.567, Unit: "kWh", Timestamp: 1612345600},
+			{Commodity: "GAS_ENERGY", Value: 567.8, Unit: "m3", Timestamp: 1612345600},
+		},
+		Bills: []BillReading{
+			{Commodity: "ELECTRICITY", Amount: 12.34, StartUTC: 1612137600, ValidUTC: 1614556799, Timestamp: 1612345600},
+		},
+		Tariffs: []TariffReading{
+			{Commodity: "ELECTRICITY", Price: 0.1523, Timestamp: 1612345600},
+		},
+		Costs: []CostReading{
+			{Commodity: "ELECTRICITY", Duration: "DAY", Cost: 1.2, Energy: 8.5, Timestamp: 1612345600},
+			{Commodity: "GAS_ENERGY", Duration: "MONTH", Cost: 20.05, Energy: 150.25, Timestamp: 1612345600},
+		},
+	}
+)
+
+func TestReadingsPoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		readings Readings
+		schema   Schema
+	}{
+		{"live_v1", liveReadings, Schema{Version: SchemaV1, Currency: "GBP"}},
+		{"live_v2", liveReadings, Schema{Version: SchemaV2, Currency: "GBP"}},
+		{"periodic_v1", periodicReadings, Schema{Version: SchemaV1, Currency: "GBP"}},
+		{"periodic_v2", periodicReadings, Schema{Version: SchemaV2, Currency: "GBP"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sb strings.Builder
+			for _, p := range tt.readings.Points(tt.schema) {
+				sb.WriteString(p.LineProtocol())
+				sb.WriteByte('\n')
+			}
+			got := sb.String()
+
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				err := ioutil.WriteFile(golden, []byte(got), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("line protocol =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestPointLineProtocol(t *testing.T) {
+	tests := []struct {
+		name  string
+		point Point
+		want  string
+	}{
+		{
+			name:  "escaping",
+			point: Point{Measurement: "meter data,v2", Tags: map[string]string{"system name": "a=b, c"}, Fields: map[string]float64{"v=al": 1.5}, Timestamp: 1},
+			want:  `meter\ data\,v2,system\ name=a\=b\,\ c v\=al=1.5 1`,
+		},
+		{
+			name:  "empty tags are skipped",
+			point: Point{Measurement: "meterdata", Tags: map[string]string{"system_name": "", "type": "GAS_ENERGY"}, Fields: map[string]float64{"val": 0}, Timestamp: 2},
+			want:  `meterdata,type=GAS_ENERGY val=0 2`,
+		},
+		{
+			name:  "keys are sorted",
+			point: Point{Measurement: "meterdata", Tags: map[string]string{"type": "ELECTRICITY", "source": "live"}, Fields: map[string]float64{"val": 350, "avg": 300.25}, Timestamp: 3},
+			want:  `meterdata,source=live,type=ELECTRICITY avg=300.25,val=350 3`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.point.LineProtocol(); got != tt.want {
+				t.Errorf("LineProtocol() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"github.com/olivercullimore/geo-energy-data-client"
+	"time"
+)
+
+const (
+	// SourceLive, SourcePeriodic and SourceHistory are where a set of readings came from
+	SourceLive     = "live"
+	SourcePeriodic = "periodic"
+	SourceHistory  = "history"
+)
+
+// Readings holds the meter readings from a single fetch of meter data.
+type Readings struct {
+	System      string               `json:"system"`
+	SystemName  string               `json:"systemName,omitempty"`
+	Source      string               `json:"source"`
+	FetchedAt   time.Time            `json:"fetchedAt"`
+	Power       []PowerReading       `json:"power,omitempty"`
+	Consumption []ConsumptionReading `json:"consumption,omitempty"`
+	Bills       []BillReading        `json:"bills,omitempty"`
+	Tariffs     []TariffReading      `json:"tariffs,omitempty"`
+	Costs       []CostReading        `json:"costs,omitempty"`
+}
+
+// PowerReading is a live power reading.
+type PowerReading struct {
+	Commodity string  `json:"commodity"`
+	Watts     float64 `json:"watts"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// ConsumptionReading is a total consumption meter reading.
+type ConsumptionReading struct {
+	Commodity string  `json:"commodity"`
+	Value     float64 `json:"value"`
+	Unit      string  `json:"unit"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// BillReading is the bill to date for a billing period.
+type BillReading struct {
+	Commodity string  `json:"commodity"`
+	Amount    float64 `json:"amount"`
+	StartUTC  int64   `json:"startUtc"`
+	ValidUTC  int64   `json:"validUtc"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// TariffReading is the active tariff price.
+type TariffReading struct {
+	Commodity string  `json:"commodity"`
+	Price     float64 `json:"price"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// CostReading is the cost and amount of energy used over a period such as a day, week or month.
+type CostReading struct {
+	Commodity string  `json:"commodity"`
+	Duration  string  `json:"duration"`
+	Cost      float64 `json:"cost"`
+	Energy    float64 `json:"energy"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// Empty reports whether there are no readings.
+func (r Readings) Empty() bool {
+	return len(r.Power) == 0 && len(r.Consumption) == 0 && len(r.Bills) == 0 && len(r.Tariffs) == 0 && len(r.Costs) == 0
+}
+
+// LiveReadings converts live meter data for a system to readings.
+func LiveReadings(system System, liveData geo.LiveMeterData, fetchedAt time.Time) Readings {
+	readings := Readings{System: system.ID, SystemName: system.Name, Source: SourceLive, FetchedAt: fetchedAt}
+
+	// Add power readings
+	if liveData.PowerTimestamp > 0 && len(liveData.Power) > 0 {
+		for _, item := range liveData.Power {
+			if item.ValueAvailable {
+				readings.Power = append(readings.Power, PowerReading{Commodity: item.Type, Watts: item.Watts, Timestamp: liveData.PowerTimestamp})
+			}
+		}
+	}
+
+	return readings
+}
+
+// LiveUsage returns the live usage of the power readings.
+func (r Readings) LiveUsage() LiveUsage {
+	liveUsage := LiveUsage{Electricity: LiveUsageData{}, Gas: LiveUsageData{}}
+	for _, reading := range r.Power {
+		if reading.Commodity == "GAS_ENERGY" {
+			liveUsage.Gas.Watts = reading.Watts
+			liveUsage.Gas.LastUpdated = reading.Timestamp
+		} else if reading.Commodity == "ELECTRICITY" {
+			liveUsage.Electricity.Watts = reading.Watts
+			liveUsage.Electricity.LastUpdated = reading.Timestamp
+		}
+	}
+	return liveUsage
+}
+
+// PeriodicUsage returns the total consumption of the consumption readings, with electricity in kWh
+// and gas in m3.
+func (r Readings) PeriodicUsage() PeriodicUsage {
+	periodicUsage := PeriodicUsage{Electricity: PeriodicUsageData{}, Gas: PeriodicUsageData{}}
+	for _, reading := range r.Consumption {
+		if reading.Commodity == "GAS_ENERGY" && reading.Unit == "m3" {
+			periodicUsage.Gas.TotalConsumption = reading.Value
+			periodicUsage.Gas.ReadingTime = reading.Timestamp
+			periodicUsage.Gas.Unit = "m3"
+		} else if reading.Commodity == "ELECTRICITY" {
+			periodicUsage.Electricity.TotalConsumption = reading.Value
+			periodicUsage.Electricity.ReadingTime = reading.Timestamp
+			periodicUsage.Electricity.Unit = "kWh"
+		}
+	}
+	return periodicUsage
+}
+
+// PeriodicReadings converts periodic meter data for a system to readings, using calorificValue to
+// convert gas readings to kWh.
+func PeriodicReadings(system System, periodicData geo.PeriodicMeterData, calorificValue float64, fetchedAt time.Time) Readings {
+	readings := Readings{System: system.ID, SystemName: system.Name, Source: SourcePeriodic, FetchedAt: fetchedAt}
+
+	// Add consumption readings
+	if periodicData.TotalConsumptionTimestamp > 0 && len(periodicData.TotalConsumptionList) > 0 {
+		for _, item := range periodicData.TotalConsumptionList {
+			if item.ValueAvailable {
+				totalConsumption := item.TotalConsumption
+				if item.CommodityType == "GAS_ENERGY" {
+					readings.Consumption = append(readings.Consumption, ConsumptionReading{Commodity: item.CommodityType, Value: item.TotalConsumption, Unit: "m3", Timestamp: item.ReadingTime})
+					totalConsumption = geo.ConvertToKWH(item.TotalConsumption, calorificValue)
+				}
+				readings.Consumption = append(readings.Consumption, ConsumptionReading{Commodity: item.CommodityType, Value: totalConsumption, Unit: "kWh", Timestamp: item.ReadingTime})
+			}
+		}
+	}
+
+	// Add bill to date
+	if periodicData.BillToDateTimestamp > 0 && len(periodicData.BillToDateList) > 0 {
+		for _, item := range periodicData.BillToDateList {
+			readings.Bills = append(readings.Bills, BillReading{Commodity: item.CommodityType, Amount: item.BillToDate, StartUTC: item.StartUTC, ValidUTC: item.ValidUTC, Timestamp: periodicData.BillToDateTimestamp})
+		}
+	}
+
+	// Add active tariff data
+	if periodicData.ActiveTariffTimestamp > 0 && len(periodicData.ActiveTariffList) > 0 {
+		for _, item := range periodicData.ActiveTariffList {
+			if item.ValueAvailable {
+				readings.Tariffs = append(readings.Tariffs, TariffReading{Commodity: item.CommodityType, Price: item.ActiveTariffPrice, Timestamp: periodicData.ActiveTariffTimestamp})
+			}
+		}
+	}
+
+	// Add current electricity costs
+	if periodicData.CurrentCostsElecTimestamp > 0 && len(periodicData.CurrentCostsElec) > 0 {
+		for _, item := range periodicData.CurrentCostsElec {
+			readings.Costs = append(readings.Costs, CostReading{Commodity: item.CommodityType, Duration: item.Duration, Cost: item.CostAmount, Energy: item.EnergyAmount, Timestamp: periodicData.CurrentCostsElecTimestamp})
+		}
+	}
+
+	// Add current gas costs
+	if periodicData.CurrentCostsGasTimestamp > 0 && len(periodicData.CurrentCostsGas) > 0 {
+		for _, item := range periodicData.CurrentCostsGas {
+			readings.Costs = append(readings.Costs, CostReading{Commodity: item.CommodityType, Duration: item.Duration, Cost: item.CostAmount, Energy: item.EnergyAmount, Timestamp: periodicData.CurrentCostsGasTimestamp})
+		}
+	}
+
+	return readings
+}
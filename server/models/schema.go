@@ -0,0 +1,72 @@
+package models
+
+const (
+	// SchemaV1 is the original schema, which tags power and energy as watts and money without units
+	SchemaV1 = 1
+	// SchemaV2 tags power as W, energy as kWh, volume as m3 and money with its currency
+	SchemaV2 = 2
+)
+
+// Schema is the version of the tags points are written with, and the currency money is in.
+type Schema struct {
+	Version  int
+	Currency string
+}
+
+// PowerUnit returns the unit power is tagged with.
+func (s Schema) PowerUnit() string {
+	if s.Version >= SchemaV2 {
+		return "W"
+	}
+	return "watts"
+}
+
+// EnergyUnit returns the unit energy is tagged with.
+func (s Schema) EnergyUnit() string {
+	if s.Version >= SchemaV2 {
+		return "kWh"
+	}
+	return "watts"
+}
+
+// Upgrade returns a point written with an earlier schema as it would be written with this one.
+// Points already written with this schema are returned unchanged.
+func (s Schema) Upgrade(p Point) Point {
+	if s.Version < SchemaV2 {
+		return p
+	}
+	tags := make(map[string]string, len(p.Tags)+1)
+	for key, value := range p.Tags {
+		tags[key] = value
+	}
+
+	switch p.Measurement {
+	case MeasurementMeterData:
+		if tags["unit"] == "watts" {
+			if tags["source"] == SourceLive {
+				tags["unit"] = "W"
+			} else {
+				tags["unit"] = "kWh"
+			}
+		}
+	case MeasurementBill:
+		if tags["unit"] == "" {
+			tags["unit"] = s.Currency
+		}
+	case MeasurementTariff:
+		if tags["unit"] == "" {
+			tags["unit"] = s.Currency + "/kWh"
+		}
+	case MeasurementCurrentCosts:
+		if tags["unit"] == "" {
+			if tags["subtype"] == "energy" {
+				tags["unit"] = "kWh"
+			} else {
+				tags["unit"] = s.Currency
+			}
+		}
+	}
+
+	p.Tags = tags
+	return p
+}
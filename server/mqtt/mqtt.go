@@ -0,0 +1,273 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// publishTimeout is the longest to wait for a message to be published
+	publishTimeout = 10 * time.Second
+	// availabilityOnline and availabilityOffline are the availability topic payloads
+	availabilityOnline  = "online"
+	availabilityOffline = "offline"
+)
+
+// Config configures a Publisher.
+type Config struct {
+	Broker          string
+	ClientID        string
+	Username        string
+	Password        string
+	TopicPrefix     string
+	DiscoveryPrefix string
+	Currency        string
+}
+
+// sensor describes a value published to its own state topic, along with the details Home
+// Assistant needs to discover it.
+type sensor struct {
+	ID          string
+	Name        string
+	Unit        string
+	DeviceClass string
+	StateClass  string
+}
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+type discoveryConfig struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	StateTopic        string          `json:"state_topic"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	UnitOfMeasurement string          `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	StateClass        string          `json:"state_class,omitempty"`
+	Device            discoveryDevice `json:"device"`
+}
+
+// Publisher publishes meter data to an MQTT broker as retained state messages, along with Home
+// Assistant discovery configs for each value. It is safe for concurrent use.
+type Publisher struct {
+	client     paho.Client
+	config     Config
+	logger     *log.Logger
+	mu         sync.Mutex
+	discovered map[string]bool
+}
+
+// New returns a Publisher, connecting to the broker in the background and retrying until
+// connected. The availability topic is set to offline by the broker if the connection is lost.
+func New(config Config, logger *log.Logger) *Publisher {
+	p := &Publisher{config: config, logger: logger, discovered: map[string]bool{}}
+
+	// Set client options
+	opts := paho.NewClientOptions()
+	opts.AddBroker(config.Broker)
+	opts.SetClientID(config.ClientID)
+	opts.SetUsername(config.Username)
+	opts.SetPassword(config.Password)
+	opts.SetWill(p.availabilityTopic(), availabilityOffline, 1, true)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	opts.SetOnConnectHandler(p.onConnect)
+	opts.SetConnectionLostHandler(func(client paho.Client, err error) {
+		logger.Printf("MQTT connection lost: %s\n", err)
+	})
+
+	// Connect to broker
+	p.client = paho.NewClient(opts)
+	p.client.Connect()
+	return p
+}
+
+// Name returns the name of the sink.
+func (p *Publisher) Name() string {
+	return "MQTT"
+}
+
+// Write publishes live power, meter readings, bill to date, active tariffs and current costs.
+func (p *Publisher) Write(readings models.Readings) error {
+	var errs []string
+
+	// Publish live power
+	for _, r := range readings.Power {
+		s := sensor{ID: commodityID(r.Commodity) + "_power", Name: commodityName(r.Commodity) + " Power", Unit: "W", DeviceClass: "power", StateClass: "measurement"}
+		errs = appendErr(errs, p.publish(readings, s, r.Watts))
+	}
+
+	// Publish consumption readings
+	for _, r := range readings.Consumption {
+		id := commodityID(r.Commodity)
+		name := commodityName(r.Commodity)
+		s := sensor{ID: id + "_total_energy", Name: name + " Total Energy", Unit: "kWh", DeviceClass: "energy", StateClass: "total_increasing"}
+		if r.Unit == "m3" {
+			s = sensor{ID: id + "_total_volume", Name: name + " Total Volume", Unit: "m³", DeviceClass: "gas", StateClass: "total_increasing"}
+		}
+		errs = appendErr(errs, p.publish(readings, s, r.Value))
+	}
+
+	// Publish bill to date
+	for _, r := range readings.Bills {
+		s := sensor{ID: commodityID(r.Commodity) + "_bill_to_date", Name: commodityName(r.Commodity) + " Bill To Date", Unit: p.config.Currency, DeviceClass: "monetary", StateClass: "total_increasing"}
+		errs = appendErr(errs, p.publish(readings, s, r.Amount))
+	}
+
+	// Publish active tariffs
+	for _, r := range readings.Tariffs {
+		s := sensor{ID: commodityID(r.Commodity) + "_tariff", Name: commodityName(r.Commodity) + " Tariff", Unit: p.config.Currency + "/kWh", StateClass: "measurement"}
+		errs = appendErr(errs, p.publish(readings, s, r.Price))
+	}
+
+	// Publish current costs
+	for _, r := range readings.Costs {
+		id := commodityID(r.Commodity) + "_" + strings.ToLower(r.Duration)
+		name := commodityName(r.Commodity) + " " + strings.Title(strings.ToLower(r.Duration))
+		cost := sensor{ID: id + "_cost", Name: name + " Cost", Unit: p.config.Currency, DeviceClass: "monetary", StateClass: "total_increasing"}
+		errs = appendErr(errs, p.publish(readings, cost, r.Cost))
+		energy := sensor{ID: id + "_energy", Name: name + " Energy", Unit: "kWh", DeviceClass: "energy", StateClass: "total_increasing"}
+		errs = appendErr(errs, p.publish(readings, energy, r.Energy))
+	}
+
+	return joinErrs(errs)
+}
+
+// Close marks the system as offline and disconnects from the broker.
+func (p *Publisher) Close() {
+	token := p.client.Publish(p.availabilityTopic(), 1, true, availabilityOffline)
+	token.WaitTimeout(publishTimeout)
+	p.client.Disconnect(250)
+}
+
+// onConnect marks the system as online and makes sure discovery configs are published again, in
+// case the broker or Home Assistant restarted.
+func (p *Publisher) onConnect(client paho.Client) {
+	p.logger.Println("Connected to MQTT broker")
+	p.mu.Lock()
+	p.discovered = map[string]bool{}
+	p.mu.Unlock()
+	client.Publish(p.availabilityTopic(), 1, true, availabilityOnline)
+}
+
+// publish publishes a sensor's retained state for the system the readings are for, first
+// publishing its discovery config if needed.
+func (p *Publisher) publish(readings models.Readings, s sensor, value float64) error {
+	// Skip publishing while disconnected rather than queueing stale values
+	if !p.client.IsConnectionOpen() {
+		return errors.New("not connected to MQTT broker")
+	}
+	err := p.discover(readings, s)
+	if err != nil {
+		return err
+	}
+	return p.send(p.stateTopic(readings.System, s), strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// discover publishes a sensor's Home Assistant discovery config for the system the readings are
+// for, if it hasn't been published since connecting. Each system is a separate device.
+func (p *Publisher) discover(readings models.Readings, s sensor) error {
+	if p.config.DiscoveryPrefix == "" {
+		return nil
+	}
+	uniqueID := fmt.Sprintf("geo_%s_%s", readings.System, s.ID)
+	p.mu.Lock()
+	done := p.discovered[uniqueID]
+	p.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	deviceName := "geo Energy Data"
+	if readings.SystemName != "" {
+		deviceName += " " + readings.SystemName
+	}
+	payload, err := json.Marshal(discoveryConfig{
+		Name:              s.Name,
+		UniqueID:          uniqueID,
+		StateTopic:        p.stateTopic(readings.System, s),
+		AvailabilityTopic: p.availabilityTopic(),
+		UnitOfMeasurement: s.Unit,
+		DeviceClass:       s.DeviceClass,
+		StateClass:        s.StateClass,
+		Device: discoveryDevice{
+			Identifiers:  []string{"geo_" + readings.System},
+			Name:         deviceName,
+			Manufacturer: "geo",
+			Model:        "Smart Meter Display",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	err = p.send(fmt.Sprintf("%s/sensor/%s/config", p.config.DiscoveryPrefix, uniqueID), string(payload))
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.discovered[uniqueID] = true
+	p.mu.Unlock()
+	return nil
+}
+
+// send publishes a retained message and waits for it to be delivered.
+func (p *Publisher) send(topic, payload string) error {
+	token := p.client.Publish(topic, 1, true, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
+	return token.Error()
+}
+
+func (p *Publisher) availabilityTopic() string {
+	return fmt.Sprintf("%s/availability", p.config.TopicPrefix)
+}
+
+func (p *Publisher) stateTopic(systemID string, s sensor) string {
+	return fmt.Sprintf("%s/%s/%s/state", p.config.TopicPrefix, systemID, s.ID)
+}
+
+// commodityID returns the topic friendly name of a geo commodity type.
+func commodityID(commodityType string) string {
+	switch commodityType {
+	case "ELECTRICITY":
+		return "electricity"
+	case "GAS_ENERGY":
+		return "gas"
+	default:
+		return strings.ToLower(commodityType)
+	}
+}
+
+// commodityName returns the display name of a geo commodity type.
+func commodityName(commodityType string) string {
+	return strings.Title(commodityID(commodityType))
+}
+
+func appendErr(errs []string, err error) []string {
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	return errs
+}
+
+func joinErrs(errs []string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(errs, "; "))
+}
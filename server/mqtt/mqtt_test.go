@@ -0,0 +1,366 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// message is a message published to the broker.
+type message struct {
+	Payload string
+	QoS     byte
+	Retain  bool
+}
+
+// broker is a minimal MQTT 3.1.1 broker, supporting just what a Publisher uses: connecting with
+// a will, publishing at QoS 0 and 1, pinging and disconnecting. It keeps retained messages and
+// every message published to each topic.
+type broker struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	conns    []net.Conn
+	willMsg  *message
+	willTo   string
+	retained map[string]message
+	history  map[string][]message
+}
+
+func newBroker(t *testing.T) *broker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &broker{listener: listener, retained: map[string]message{}, history: map[string][]message{}}
+	go b.serve()
+	t.Cleanup(b.close)
+	return b
+}
+
+func (b *broker) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *broker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns = append(b.conns, conn)
+		b.mu.Unlock()
+		go b.handle(conn)
+	}
+}
+
+// drop closes every connection without the client disconnecting, as if the network failed.
+func (b *broker) drop() {
+	b.mu.Lock()
+	conns := b.conns
+	b.conns = nil
+	b.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func (b *broker) close() {
+	b.listener.Close()
+	b.drop()
+}
+
+// handle serves a connection, publishing its will if it ends without a DISCONNECT.
+func (b *broker) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var will *message
+	var willTopic string
+	for {
+		header, body, err := readPacket(r)
+		if err != nil {
+			if will != nil {
+				b.publish(willTopic, *will)
+			}
+			return
+		}
+		switch header >> 4 {
+		case 1: // CONNECT
+			willTopic, will = parseConnect(body)
+			b.mu.Lock()
+			b.willTo, b.willMsg = willTopic, will
+			b.mu.Unlock()
+			conn.Write([]byte{0x20, 2, 0, 0})
+		case 3: // PUBLISH
+			qos := header >> 1 & 3
+			topic, rest := readString(body)
+			if qos > 0 {
+				conn.Write([]byte{0x40, 2, rest[0], rest[1]})
+				rest = rest[2:]
+			}
+			b.publish(topic, message{Payload: string(rest), QoS: qos, Retain: header&1 == 1})
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func (b *broker) publish(topic string, m message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.history[topic] = append(b.history[topic], m)
+	if m.Retain {
+		b.retained[topic] = m
+	}
+}
+
+// retainedWith returns the retained messages of the topics starting with prefix.
+func (b *broker) retainedWith(prefix string) map[string]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	messages := map[string]string{}
+	for topic, m := range b.retained {
+		if strings.HasPrefix(topic, prefix) {
+			messages[topic] = m.Payload
+		}
+	}
+	return messages
+}
+
+// payloads returns the payloads published to a topic, oldest first.
+func (b *broker) payloads(topic string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var payloads []string
+	for _, m := range b.history[topic] {
+		payloads = append(payloads, m.Payload)
+	}
+	return payloads
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(c&127) * multiplier
+		if c&128 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+func readString(b []byte) (string, []byte) {
+	n := binary.BigEndian.Uint16(b)
+	return string(b[2 : 2+n]), b[2+n:]
+}
+
+// parseConnect returns the will of a CONNECT packet, if it has one.
+func parseConnect(body []byte) (string, *message) {
+	_, rest := readString(body) // Protocol name
+	flags := rest[1]
+	_, rest = readString(rest[4:]) // Client ID
+	if flags&0x04 == 0 {
+		return "", nil
+	}
+	topic, rest := readString(rest)
+	payload, _ := readString(rest)
+	return topic, &message{Payload: payload, QoS: flags >> 3 & 3, Retain: flags&0x20 != 0}
+}
+
+// waitFor polls until ok returns true, failing the test if it doesn't within a few seconds.
+func waitFor(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestPublisher(t *testing.T, b *broker) *Publisher {
+	p := New(Config{
+		Broker:          b.url(),
+		ClientID:        "geo-energy-data-test",
+		TopicPrefix:     "geo-energy-data",
+		DiscoveryPrefix: "homeassistant",
+		Currency:        "GBP",
+	}, log.New(ioutil.Discard, "", 0))
+	waitFor(t, "availability online", func() bool {
+		return b.retainedWith("geo-energy-data/availability")["geo-energy-data/availability"] == "online"
+	})
+	return p
+}
+
+var testReadings = models.Readings{
+	System:     "system-1",
+	SystemName: "Home",
+	Power:      []models.PowerReading{{Commodity: "ELECTRICITY", Watts: 350}},
+	Consumption: []models.ConsumptionReading{
+		{Commodity: "ELECTRICITY", Value: 1234.5, Unit: "kWh"},
+		{Commodity: "GAS_ENERGY", Value: 567.8, Unit: "m3"},
+	},
+	Bills:   []models.BillReading{{Commodity: "ELECTRICITY", Amount: 12.34}},
+	Tariffs: []models.TariffReading{{Commodity: "ELECTRICITY", Price: 0.1523}},
+	Costs:   []models.CostReading{{Commodity: "GAS_ENERGY", Duration: "DAY", Cost: 1.2, Energy: 8.5}},
+}
+
+func TestPublisherState(t *testing.T) {
+	b := newBroker(t)
+	p := newTestPublisher(t, b)
+	defer p.Close()
+
+	err := p.Write(testReadings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"geo-energy-data/system-1/electricity_power/state":        "350",
+		"geo-energy-data/system-1/electricity_total_energy/state": "1234.5",
+		"geo-energy-data/system-1/gas_total_volume/state":         "567.8",
+		"geo-energy-data/system-1/electricity_bill_to_date/state": "12.34",
+		"geo-energy-data/system-1/electricity_tariff/state":       "0.1523",
+		"geo-energy-data/system-1/gas_day_cost/state":             "1.2",
+		"geo-energy-data/system-1/gas_day_energy/state":           "8.5",
+	}
+	got := b.retainedWith("geo-energy-data/system-1/")
+	if len(got) != len(want) {
+		t.Errorf("got %d retained state topics, want %d: %v", len(got), len(want), got)
+	}
+	for topic, payload := range want {
+		if got[topic] != payload {
+			t.Errorf("%s = %q, want %q", topic, got[topic], payload)
+		}
+	}
+}
+
+func TestPublisherDiscovery(t *testing.T) {
+	b := newBroker(t)
+	p := newTestPublisher(t, b)
+	defer p.Close()
+
+	err := p.Write(testReadings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	availability := `"availability_topic":"geo-energy-data/availability",`
+	device := `"device":{"identifiers":["geo_system-1"],"name":"geo Energy Data Home","manufacturer":"geo","model":"Smart Meter Display"}}`
+	want := map[string]string{
+		"homeassistant/sensor/geo_system-1_electricity_power/config":        `{"name":"Electricity Power","unique_id":"geo_system-1_electricity_power","state_topic":"geo-energy-data/system-1/electricity_power/state",` + availability + `"unit_of_measurement":"W","device_class":"power","state_class":"measurement",` + device,
+		"homeassistant/sensor/geo_system-1_electricity_total_energy/config": `{"name":"Electricity Total Energy","unique_id":"geo_system-1_electricity_total_energy","state_topic":"geo-energy-data/system-1/electricity_total_energy/state",` + availability + `"unit_of_measurement":"kWh","device_class":"energy","state_class":"total_increasing",` + device,
+		"homeassistant/sensor/geo_system-1_gas_total_volume/config":         `{"name":"Gas Total Volume","unique_id":"geo_system-1_gas_total_volume","state_topic":"geo-energy-data/system-1/gas_total_volume/state",` + availability + `"unit_of_measurement":"m³","device_class":"gas","state_class":"total_increasing",` + device,
+		"homeassistant/sensor/geo_system-1_electricity_bill_to_date/config": `{"name":"Electricity Bill To Date","unique_id":"geo_system-1_electricity_bill_to_date","state_topic":"geo-energy-data/system-1/electricity_bill_to_date/state",` + availability + `"unit_of_measurement":"GBP","device_class":"monetary","state_class":"total_increasing",` + device,
+		"homeassistant/sensor/geo_system-1_electricity_tariff/config":       `{"name":"Electricity Tariff","unique_id":"geo_system-1_electricity_tariff","state_topic":"geo-energy-data/system-1/electricity_tariff/state",` + availability + `"unit_of_measurement":"GBP/kWh","state_class":"measurement",` + device,
+		"homeassistant/sensor/geo_system-1_gas_day_cost/config":             `{"name":"Gas Day Cost","unique_id":"geo_system-1_gas_day_cost","state_topic":"geo-energy-data/system-1/gas_day_cost/state",` + availability + `"unit_of_measurement":"GBP","device_class":"monetary","state_class":"total_increasing",` + device,
+		"homeassistant/sensor/geo_system-1_gas_day_energy/config":           `{"name":"Gas Day Energy","unique_id":"geo_system-1_gas_day_energy","state_topic":"geo-energy-data/system-1/gas_day_energy/state",` + availability + `"unit_of_measurement":"kWh","device_class":"energy","state_class":"total_increasing",` + device,
+	}
+	got := b.retainedWith("homeassistant/")
+	if len(got) != len(want) {
+		var topics []string
+		for topic := range got {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+		t.Errorf("got %d discovery configs, want %d: %v", len(got), len(want), topics)
+	}
+	for topic, payload := range want {
+		if got[topic] != payload {
+			t.Errorf("%s =\n%s\nwant\n%s", topic, got[topic], payload)
+		}
+	}
+
+	// Discovery configs are only published once per connection
+	err = p.Write(testReadings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic := "homeassistant/sensor/geo_system-1_electricity_power/config"
+	if n := len(b.payloads(topic)); n != 1 {
+		t.Errorf("%s published %d times, want 1", topic, n)
+	}
+}
+
+func TestPublisherAvailability(t *testing.T) {
+	b := newBroker(t)
+	p := newTestPublisher(t, b)
+
+	// The broker sets availability offline if the connection is lost
+	b.mu.Lock()
+	willTopic, will := b.willTo, b.willMsg
+	b.mu.Unlock()
+	if willTopic != "geo-energy-data/availability" || will == nil || *will != (message{Payload: "offline", QoS: 1, Retain: true}) {
+		t.Fatalf("will = %q %+v, want retained offline on geo-energy-data/availability", willTopic, will)
+	}
+	err := p.Write(testReadings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.drop()
+	waitFor(t, "will to be published", func() bool {
+		payloads := b.payloads("geo-energy-data/availability")
+		return len(payloads) >= 2 && payloads[1] == "offline"
+	})
+
+	// Availability and discovery configs are published again on reconnecting
+	waitFor(t, "reconnect", func() bool {
+		return len(b.payloads("geo-energy-data/availability")) >= 3 && p.client.IsConnectionOpen()
+	})
+	if payloads := b.payloads("geo-energy-data/availability"); payloads[2] != "online" {
+		t.Errorf("availability after reconnecting = %q, want online", payloads[2])
+	}
+	err = p.Write(testReadings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic := "homeassistant/sensor/geo_system-1_electricity_power/config"
+	if n := len(b.payloads(topic)); n != 2 {
+		t.Errorf("%s published %d times, want 2", topic, n)
+	}
+
+	// Closing sets availability offline without relying on the will
+	p.Close()
+	waitFor(t, "offline", func() bool {
+		return b.retainedWith("geo-energy-data/availability")["geo-energy-data/availability"] == "offline"
+	})
+}
+
+func TestPublisherDisconnected(t *testing.T) {
+	b := newBroker(t)
+	p := newTestPublisher(t, b)
+	defer p.client.Disconnect(0)
+	b.close()
+	waitFor(t, "disconnect", func() bool {
+		return !p.client.IsConnectionOpen()
+	})
+
+	err := p.Write(testReadings)
+	if err == nil || !strings.Contains(err.Error(), "not connected to MQTT broker") {
+		t.Errorf("Write() = %v, want not connected error", err)
+	}
+}
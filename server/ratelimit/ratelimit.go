@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// sweepInterval is how often a Limiter forgets the buckets of idle keys
+	sweepInterval = time.Minute
+)
+
+// Bucket is a token bucket, refilling at a steady rate up to its burst size. A nil Bucket allows
+// everything. It is safe for concurrent use.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full Bucket refilling perMinute tokens a minute and holding up to burst,
+// or nil if perMinute isn't positive.
+func NewBucket(perMinute, burst int) *Bucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &Bucket{rate: float64(perMinute) / 60, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Take takes a token if there is one, otherwise returning how long until there will be.
+func (b *Bucket) Take() (bool, time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, b.wait()
+}
+
+// Wait returns how long until a token will be available.
+func (b *Bucket) Wait() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	return b.wait()
+}
+
+// full reports whether the bucket has refilled completely by now.
+func (b *Bucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// refill adds the tokens gained since the last refill. It must be called with the mutex held.
+func (b *Bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// wait returns how long until a token will be available. It must be called with the mutex held.
+func (b *Bucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Limiter keeps a Bucket for each key, such as a client's API key or IP address. A nil Limiter
+// allows everything. It is safe for concurrent use.
+type Limiter struct {
+	perMinute int
+	burst     int
+	mu        sync.Mutex
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+}
+
+// New returns a Limiter allowing each key perMinute requests a minute in bursts of up to burst,
+// or nil if perMinute isn't positive.
+func New(perMinute, burst int) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &Limiter{perMinute: perMinute, burst: burst, buckets: map[string]*Bucket{}, lastSweep: time.Now()}
+}
+
+// Allow takes a token from the bucket of key if there is one, otherwise returning how long until
+// there will be.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = NewBucket(l.perMinute, l.burst)
+		l.buckets[key] = b
+	}
+	l.mu.Unlock()
+	return b.Take()
+}
+
+// sweep forgets the buckets that have refilled completely, which are no different to new ones. It
+// must be called with the mutex held.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
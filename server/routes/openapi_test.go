@@ -0,0 +1,344 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openAPIDoc is a parsed OpenAPI document, with just enough of JSON Schema to check responses.
+type openAPIDoc map[string]interface{}
+
+// resolve follows $ref until it reaches a node without one.
+func (d openAPIDoc) resolve(node map[string]interface{}) map[string]interface{} {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		var target interface{} = map[string]interface{}(d)
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			target = target.(map[string]interface{})[part]
+		}
+		node = target.(map[string]interface{})
+	}
+}
+
+// properties returns the properties declared by a schema, including those of its allOf schemas.
+func (d openAPIDoc) properties(schema map[string]interface{}) map[string]interface{} {
+	schema = d.resolve(schema)
+	properties := map[string]interface{}{}
+	for name, property := range object(schema["properties"]) {
+		properties[name] = property
+	}
+	for _, sub := range array(schema["allOf"]) {
+		for name, property := range d.properties(sub.(map[string]interface{})) {
+			properties[name] = property
+		}
+	}
+	return properties
+}
+
+// validate returns how value doesn't match schema. Objects with declared properties must not have
+// any others, so undocumented fields are caught too.
+func (d openAPIDoc) validate(schema map[string]interface{}, value interface{}, path string) []string {
+	return d.check(schema, value, path, true)
+}
+
+// check returns how value doesn't match schema, checking the properties of objects only if
+// properties is true, as those of an allOf schema are checked once for all of its schemas.
+func (d openAPIDoc) check(schema map[string]interface{}, value interface{}, path string, properties bool) []string {
+	schema = d.resolve(schema)
+	var errs []string
+	for _, sub := range array(schema["allOf"]) {
+		errs = append(errs, d.check(sub.(map[string]interface{}), value, path, false)...)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || allowed == value
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: %v is not one of %v", path, value, enum))
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: expected object, got %T", path, value))
+		}
+		for _, name := range array(schema["required"]) {
+			if _, ok := fields[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required property %s", path, name))
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: expected array, got %T", path, value))
+		}
+		for i, item := range items {
+			errs = append(errs, d.validate(object(schema["items"]), item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: expected string, got %T", path, value))
+		}
+		switch schema["format"] {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %q is not a date-time", path, s))
+			}
+		case "date":
+			if _, err := time.Parse("2006-01-02", s); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %q is not a date", path, s))
+			}
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			errs = append(errs, fmt.Sprintf("%s: expected number, got %T", path, value))
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			errs = append(errs, fmt.Sprintf("%s: expected integer, got %v", path, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			errs = append(errs, fmt.Sprintf("%s: expected boolean, got %T", path, value))
+		}
+	}
+
+	// Check the properties of objects
+	fields, ok := value.(map[string]interface{})
+	if !ok || !properties {
+		return errs
+	}
+	declared := d.properties(schema)
+	for name, field := range fields {
+		property, ok := declared[name]
+		if ok {
+			errs = append(errs, d.validate(property.(map[string]interface{}), field, path+"."+name)...)
+		} else if len(declared) > 0 {
+			errs = append(errs, fmt.Sprintf("%s: undocumented property %s", path, name))
+		}
+	}
+	return errs
+}
+
+// checkResponse checks the status and body of a response against those declared for an operation.
+func (d openAPIDoc) checkResponse(t *testing.T, operation map[string]interface{}, resp *http.Response, body []byte) {
+	t.Helper()
+	responses := object(operation["responses"])
+	declared, ok := responses[strconv.Itoa(resp.StatusCode)].(map[string]interface{})
+	if !ok {
+		declared, ok = responses["default"].(map[string]interface{})
+		if !ok || resp.StatusCode < 400 {
+			t.Fatalf("status %d isn't declared: %s", resp.StatusCode, body)
+		}
+	}
+	declared = d.resolve(declared)
+
+	// Check body matches the schema of its content type
+	content := object(declared["content"])
+	if len(content) == 0 {
+		if len(body) > 0 {
+			t.Errorf("expected no body, got %s", body)
+		}
+		return
+	}
+	contentType := strings.Split(resp.Header.Get("Content-Type"), ";")[0]
+	media, ok := content[contentType].(map[string]interface{})
+	if !ok {
+		t.Fatalf("content type %q isn't declared", contentType)
+	}
+	if contentType != "application/json" {
+		return
+	}
+	var value interface{}
+	err := json.Unmarshal(body, &value)
+	if err != nil {
+		t.Fatalf("invalid JSON body %s: %s", body, err)
+	}
+	for _, e := range d.validate(object(media["schema"]), value, "body") {
+		t.Error(e)
+	}
+}
+
+func object(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+func array(v interface{}) []interface{} {
+	a, _ := v.([]interface{})
+	return a
+}
+
+// loadOpenAPI gets the OpenAPI document served by the API.
+func loadOpenAPI(t *testing.T, server *httptest.Server) openAPIDoc {
+	resp, err := http.Get(server.URL + "/api/v1/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var doc openAPIDoc
+	err = json.NewDecoder(resp.Body).Decode(&doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// operations returns the paths and methods of every operation in the document, in order.
+func (d openAPIDoc) operations() [][2]string {
+	var operations [][2]string
+	for path, item := range object(d["paths"]) {
+		for method := range object(item) {
+			operations = append(operations, [2]string{path, strings.ToUpper(method)})
+		}
+	}
+	sort.Slice(operations, func(i, j int) bool {
+		return operations[i][0]+" "+operations[i][1] < operations[j][0]+" "+operations[j][1]
+	})
+	return operations
+}
+
+// contractRequest makes a request to an operation with header, returning the response and body.
+func contractRequest(t *testing.T, server *httptest.Server, method, path string, header http.Header) (*http.Response, []byte) {
+	t.Helper()
+	url := server.URL + "/api/v1" + path
+
+	// Open WebSockets and streams, reading no more than the headers
+	if path == "/ws" {
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), header)
+		if err != nil {
+			t.Fatalf("unable to open WebSocket: %s", err)
+		}
+		conn.Close()
+		return resp, nil
+	}
+	var body *strings.Reader
+	switch {
+	case method == http.MethodPost && path == "/keys":
+		body = strings.NewReader(`{"name":"contract-new","scopes":["read-live"]}`)
+	case method == http.MethodPost && path == "/backfill":
+		url += "?from=2021-01-01&to=2021-01-02"
+	}
+	var req *http.Request
+	var err error
+	if body != nil {
+		req, err = http.NewRequest(method, url, body)
+	} else {
+		req, err = http.NewRequest(method, url, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return resp, nil
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, respBody
+}
+
+// TestOpenAPIContract calls every operation in the OpenAPI document, checking the responses
+// match it, and that every v1 route is documented.
+func TestOpenAPIContract(t *testing.T) {
+	env := newTestEnv(t)
+	router := newTestRouter(env)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	doc := loadOpenAPI(t, server)
+
+	// Create the keys rotated and revoked
+	for _, name := range []string{"contract-rotate", "contract-revoke"} {
+		_, _, err := env.Keys.Create(name, []string{"read-live"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	documented := map[string]bool{}
+	for _, op := range doc.operations() {
+		path, method := op[0], op[1]
+		documented[method+" "+path] = true
+		operation := object(object(object(doc["paths"])[path])[strings.ToLower(method)])
+		requestPath := strings.NewReplacer("{id}", systemOK, "{name}", "contract-rotate").Replace(path)
+		if method == http.MethodDelete {
+			requestPath = strings.Replace(path, "{name}", "contract-revoke", 1)
+		}
+
+		t.Run(method+" "+path, func(t *testing.T) {
+			// Check a successful request
+			header := http.Header{"X-Api-Key": {adminKey}}
+			resp, body := contractRequest(t, server, method, requestPath, header)
+			if resp.StatusCode >= 400 {
+				t.Errorf("status %d: %s", resp.StatusCode, body)
+			}
+			doc.checkResponse(t, operation, resp, body)
+
+			// Check the errors returned without credentials and for unknown systems
+			if security, ok := operation["security"].([]interface{}); ok && len(security) == 0 {
+				return
+			}
+			if path == "/ws" {
+				return
+			}
+			resp, body = contractRequest(t, server, method, requestPath, http.Header{})
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("status without credentials = %d, want 401", resp.StatusCode)
+			}
+			doc.checkResponse(t, operation, resp, body)
+			if strings.Contains(path, "{id}") {
+				resp, body = contractRequest(t, server, method, strings.Replace(path, "{id}", "no-such-system", 1), header)
+				if resp.StatusCode != http.StatusNotFound {
+					t.Errorf("status for unknown system = %d, want 404", resp.StatusCode)
+				}
+				doc.checkResponse(t, operation, resp, body)
+			}
+		})
+	}
+
+	// Check every v1 route is documented
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(template, "/api/v1/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			if !documented[method+" "+strings.TrimPrefix(template, "/api/v1")] {
+				t.Errorf("%s %s isn't documented", method, template)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"github.com/gorilla/mux"
+	"github.com/olivercullimore/geo-energy-data/server/auth"
 	"github.com/olivercullimore/geo-energy-data/server/controllers"
 	"github.com/olivercullimore/geo-energy-data/server/middleware"
 	"github.com/olivercullimore/geo-energy-data/server/models"
@@ -10,20 +11,91 @@ import (
 
 func Initialize(r *mux.Router, env *models.Env) {
 
-	// Handle API routes (with Logging & CORS)
+	// Handle metrics route if enabled (with Logging & rate limiting by IP & Auth with read-live scope)
+	if env.Metrics != nil {
+		metricsRouter := r.NewRoute().Subrouter()
+		metricsRouter.Use(middleware.Logging(env))
+		metricsRouter.Use(middleware.RateLimitIP(env))
+		metricsRouter.Use(middleware.Auth(env))
+		metricsRouter.Use(middleware.RateLimitKey(env))
+		metricsRouter.Use(middleware.Scope(env, auth.ScopeReadLive))
+		metricsRouter.Handle("/metrics", env.Metrics).Methods(http.MethodGet)
+	}
+
+	// Handle API routes (with Logging & CORS & rate limiting by IP)
 	apiRouter := r.PathPrefix("/api").Subrouter()
 	apiRouter.Use(middleware.Logging(env))
-	apiRouter.Use(middleware.CORS)
-	apiRouter.NotFoundHandler = &middleware.AppHandler{env, controllers.APINotFound}
-	apiRouter.MethodNotAllowedHandler = &middleware.AppHandler{env, controllers.APIMethodNotAllowed}
-	apiRouter.Handle("/status", &middleware.AppHandler{env, controllers.APIStatus}).Methods(http.MethodGet)
+	apiRouter.Use(middleware.CORS(env))
+	apiRouter.Use(middleware.RateLimitIP(env))
+	// Answer CORS preflight requests in the not found and method not allowed handlers, which
+	// handle OPTIONS requests as no route matches them, and rate limit them like any other request
+	apiRouter.NotFoundHandler = middleware.CORS(env)(middleware.RateLimitIP(env)(&middleware.AppHandler{Env: env, Handler: controllers.APINotFound}))
+	apiRouter.MethodNotAllowedHandler = middleware.CORS(env)(middleware.RateLimitIP(env)(&middleware.AppHandler{Env: env, Handler: controllers.APIMethodNotAllowed}))
+	apiRouter.Handle("/status", &middleware.AppHandler{Env: env, Handler: controllers.APIStatus}).Methods(http.MethodGet)
+
+	// Handle public v1 API routes (with Logging & CORS)
+	apiRouter.Handle("/v1/openapi.json", &middleware.AppHandler{Env: env, Handler: controllers.APIGetOpenAPI}).Methods(http.MethodGet)
 
-	// Handle Authenticated API routes (with Logging & CORS & Auth)
+	// Handle deprecated Authenticated API routes, superseded by v1 (with Logging & CORS & Auth)
 	apiAuthRouter := apiRouter.PathPrefix("/beta").Subrouter()
 	apiAuthRouter.Use(middleware.Auth(env))
-	apiAuthRouter.Handle("/currentusage", &middleware.AppHandler{env, controllers.APIGetCurrentUsage}).Methods(http.MethodGet)
-	apiAuthRouter.Handle("/meterreadings", &middleware.AppHandler{env, controllers.APIGetMeterReadings}).Methods(http.MethodGet)
-	apiAuthRouter.Handle("/live", &middleware.AppHandler{env, controllers.APIGetLiveData}).Methods(http.MethodGet)
-	apiAuthRouter.Handle("/periodic", &middleware.AppHandler{env, controllers.APIGetPeriodicData}).Methods(http.MethodGet)
+	apiAuthRouter.Use(middleware.RateLimitKey(env))
+	apiAuthRouter.Use(middleware.Deprecated("/api/beta", "/api/v1"))
+	betaLiveRouter := apiAuthRouter.NewRoute().Subrouter()
+	betaLiveRouter.Use(middleware.Scope(env, auth.ScopeReadLive))
+	betaLiveRouter.Handle("/currentusage", &middleware.AppHandler{Env: env, Handler: controllers.APIGetCurrentUsage}).Methods(http.MethodGet)
+	betaLiveRouter.Handle("/meterreadings", &middleware.AppHandler{Env: env, Handler: controllers.APIGetMeterReadings}).Methods(http.MethodGet)
+	betaLiveRouter.Handle("/live", &middleware.AppHandler{Env: env, Handler: controllers.APIGetLiveData}).Methods(http.MethodGet)
+	betaLiveRouter.Handle("/periodic", &middleware.AppHandler{Env: env, Handler: controllers.APIGetPeriodicData}).Methods(http.MethodGet)
+	betaHistoryRouter := apiAuthRouter.NewRoute().Subrouter()
+	betaHistoryRouter.Use(middleware.Scope(env, auth.ScopeReadHistory))
+	betaHistoryRouter.Handle("/backfill", &middleware.AppHandler{Env: env, Handler: controllers.APIGetBackfill}).Methods(http.MethodGet)
+	betaAdminRouter := apiAuthRouter.NewRoute().Subrouter()
+	betaAdminRouter.Use(middleware.Scope(env, auth.ScopeAdmin))
+	betaAdminRouter.Handle("/backfill", &middleware.AppHandler{Env: env, Handler: controllers.APIStartBackfill}).Methods(http.MethodPost)
+
+	// Handle Authenticated v1 API routes, for the default system unless under a system (with Logging & CORS & Auth)
+	apiV1Router := apiRouter.PathPrefix("/v1").Subrouter()
+	apiV1Router.Use(middleware.Auth(env))
+	apiV1Router.Use(middleware.RateLimitKey(env))
+
+	// Handle live data routes (with read-live scope)
+	v1LiveRouter := apiV1Router.NewRoute().Subrouter()
+	v1LiveRouter.Use(middleware.Scope(env, auth.ScopeReadLive))
+	v1LiveRouter.Handle("/currentusage", &middleware.AppHandler{Env: env, Handler: controllers.APIGetCurrentUsage}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/meterreadings", &middleware.AppHandler{Env: env, Handler: controllers.APIGetMeterReadings}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/live", &middleware.AppHandler{Env: env, Handler: controllers.APIGetLiveReadings}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/periodic", &middleware.AppHandler{Env: env, Handler: controllers.APIGetPeriodicReadings}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/bill", &middleware.AppHandler{Env: env, Handler: controllers.APIGetBills}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/tariffs", &middleware.AppHandler{Env: env, Handler: controllers.APIGetTariffs}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/costs", &middleware.AppHandler{Env: env, Handler: controllers.APIGetCosts}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/systems", &middleware.AppHandler{Env: env, Handler: controllers.APIGetSystems}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/systems/{id}/currentusage", &middleware.AppHandler{Env: env, Handler: controllers.APIGetCurrentUsage}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/systems/{id}/meterreadings", &middleware.AppHandler{Env: env, Handler: controllers.APIGetMeterReadings}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/systems/{id}/live", &middleware.AppHandler{Env: env, Handler: controllers.APIGetLiveReadings}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/systems/{id}/periodic", &middleware.AppHandler{Env: env, Handler: controllers.APIGetPeriodicReadings}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/systems/{id}/bill", &middleware.AppHandler{Env: env, Handler: controllers.APIGetBills}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/systems/{id}/tariffs", &middleware.AppHandler{Env: env, Handler: controllers.APIGetTariffs}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/systems/{id}/costs", &middleware.AppHandler{Env: env, Handler: controllers.APIGetCosts}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/stream/live", &middleware.AppHandler{Env: env, Handler: controllers.APIStreamLive}).Methods(http.MethodGet)
+	v1LiveRouter.Handle("/ws", &middleware.AppHandler{Env: env, Handler: controllers.APIWebSocket}).Methods(http.MethodGet)
+
+	// Handle history routes (with read-history scope)
+	v1HistoryRouter := apiV1Router.NewRoute().Subrouter()
+	v1HistoryRouter.Use(middleware.Scope(env, auth.ScopeReadHistory))
+	v1HistoryRouter.Handle("/history/power", &middleware.AppHandler{Env: env, Handler: controllers.APIGetPowerHistory}).Methods(http.MethodGet)
+	v1HistoryRouter.Handle("/history/energy", &middleware.AppHandler{Env: env, Handler: controllers.APIGetEnergyHistory}).Methods(http.MethodGet)
+	v1HistoryRouter.Handle("/systems/{id}/history/power", &middleware.AppHandler{Env: env, Handler: controllers.APIGetPowerHistory}).Methods(http.MethodGet)
+	v1HistoryRouter.Handle("/systems/{id}/history/energy", &middleware.AppHandler{Env: env, Handler: controllers.APIGetEnergyHistory}).Methods(http.MethodGet)
+	v1HistoryRouter.Handle("/backfill", &middleware.AppHandler{Env: env, Handler: controllers.APIGetBackfill}).Methods(http.MethodGet)
+
+	// Handle admin routes (with admin scope)
+	v1AdminRouter := apiV1Router.NewRoute().Subrouter()
+	v1AdminRouter.Use(middleware.Scope(env, auth.ScopeAdmin))
+	v1AdminRouter.Handle("/backfill", &middleware.AppHandler{Env: env, Handler: controllers.APIStartBackfill}).Methods(http.MethodPost)
+	v1AdminRouter.Handle("/keys", &middleware.AppHandler{Env: env, Handler: controllers.APIGetKeys}).Methods(http.MethodGet)
+	v1AdminRouter.Handle("/keys", &middleware.AppHandler{Env: env, Handler: controllers.APICreateKey}).Methods(http.MethodPost)
+	v1AdminRouter.Handle("/keys/{name}/rotate", &middleware.AppHandler{Env: env, Handler: controllers.APIRotateKey}).Methods(http.MethodPost)
+	v1AdminRouter.Handle("/keys/{name}", &middleware.AppHandler{Env: env, Handler: controllers.APIRevokeKey}).Methods(http.MethodDelete)
 
 }
@@ -0,0 +1,439 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/olivercullimore/geo-energy-data-client"
+	"github.com/olivercullimore/geo-energy-data/server/auth"
+	"github.com/olivercullimore/geo-energy-data/server/geoapi"
+	"github.com/olivercullimore/geo-energy-data/server/metrics"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"github.com/olivercullimore/geo-energy-data/server/ratelimit"
+	"github.com/olivercullimore/geo-energy-data/server/snapshot"
+	"github.com/olivercullimore/geo-energy-data/server/stream"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	// adminKey has every scope and liveKey only has the read-live scope
+	adminKey = "admin-key"
+	liveKey  = "live-key"
+)
+
+// The systems of the test env: one with data, one the geo API has no data for yet, one it
+// doesn't know and one it fails to return data for.
+const (
+	systemOK      = "system-1"
+	systemEmpty   = "system-empty"
+	systemUnknown = "system-unknown"
+	systemFailing = "system-failing"
+)
+
+// geoStub serves the geo API endpoints used by the geo client.
+func geoStub(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/usersservice/v2/login" {
+		_ = json.NewEncoder(w).Encode(geo.AuthData{AccessToken: "access-token"})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer access-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Get system the request is for
+	var live bool
+	var systemID string
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/userapi/system/smets2-live-data/"):
+		live, systemID = true, strings.TrimPrefix(r.URL.Path, "/api/userapi/system/smets2-live-data/")
+	case strings.HasPrefix(r.URL.Path, "/api/userapi/system/smets2-periodic-data/"):
+		systemID = strings.TrimPrefix(r.URL.Path, "/api/userapi/system/smets2-periodic-data/")
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch systemID {
+	case systemEmpty:
+		_, _ = w.Write([]byte(`{"id":"` + systemEmpty + `"}`))
+		return
+	case systemFailing:
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	case systemOK:
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Respond with meter data
+	now := time.Now().Unix()
+	if live {
+		_ = json.NewEncoder(w).Encode(geo.LiveMeterData{
+			ID:             systemOK,
+			Power:          []geo.LiveMeterDataPower{{Type: "ELECTRICITY", Watts: 350, ValueAvailable: true}, {Type: "GAS_ENERGY", Watts: 0, ValueAvailable: true}},
+			PowerTimestamp: now,
+		})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(geo.PeriodicMeterData{
+		ID: systemOK,
+		TotalConsumptionList: []geo.PeriodicMeterDataConsumption{
+			{CommodityType: "ELECTRICITY", ReadingTime: now, TotalConsumption: 1234.5, ValueAvailable: true},
+			{CommodityType: "GAS_ENERGY", ReadingTime: now, TotalConsumption: 567.8, ValueAvailable: true},
+		},
+		TotalConsumptionTimestamp: now,
+		BillToDateList:            []geo.PeriodicMeterDataBillToDate{{CommodityType: "ELECTRICITY", BillToDate: 1234, StartUTC: now - 86400, ValidUTC: now + 86400, ValueAvailable: true}},
+		BillToDateTimestamp:       now,
+		ActiveTariffList:          []geo.PeriodicMeterDataActiveTariff{{CommodityType: "ELECTRICITY", ActiveTariffPrice: 15.23, ValueAvailable: true}},
+		ActiveTariffTimestamp:     now,
+		CurrentCostsElec:          []geo.PeriodicMeterDataCurrentCost{{CommodityType: "ELECTRICITY", Duration: "DAY", CostAmount: 120, EnergyAmount: 8.5}},
+		CurrentCostsElecTimestamp: now,
+	})
+}
+
+// geoTransport sends requests to the geo API to a handler, and everything else on to the next
+// transport.
+type geoTransport struct {
+	handler http.HandlerFunc
+	next    http.RoundTripper
+}
+
+func (t geoTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Host != "api.geotogether.com" {
+		return t.next.RoundTrip(r)
+	}
+	w := httptest.NewRecorder()
+	t.handler(w, r)
+	return w.Result(), nil
+}
+
+// stubHistory returns a single point for each commodity.
+type stubHistory struct{}
+
+func (stubHistory) Power(query models.HistoryQuery) ([]models.HistorySeries, error) {
+	return []models.HistorySeries{{Commodity: "ELECTRICITY", Unit: "W", Points: []models.HistoryPoint{{Time: query.From, Value: 350}}}}, nil
+}
+
+func (stubHistory) Energy(query models.HistoryQuery) ([]models.HistorySeries, error) {
+	return []models.HistorySeries{{Commodity: "ELECTRICITY", Unit: "kWh", Points: []models.HistoryPoint{{Time: query.From, Value: 8.5}}}}, nil
+}
+
+// stubBackfill reports starting a backfill without running it.
+type stubBackfill struct{}
+
+func (stubBackfill) Start(from, to time.Time) error {
+	return nil
+}
+
+func (stubBackfill) Run(from, to time.Time) error {
+	return nil
+}
+
+func (stubBackfill) Stop() {}
+
+func (stubBackfill) Progress() models.BackfillProgress {
+	return models.BackfillProgress{From: "2021-01-01", To: "2021-01-02", Next: "2021-01-02", DaysTotal: 2, DaysDone: 1, Records: 12, Running: true}
+}
+
+// newTestEnv returns an env with every feature enabled and a stubbed geo API, which is used
+// instead of the real one until the test finishes.
+func newTestEnv(t *testing.T) *models.Env {
+	transport := http.DefaultTransport
+	http.DefaultTransport = geoTransport{handler: geoStub, next: transport}
+	t.Cleanup(func() {
+		http.DefaultTransport = transport
+	})
+
+	logger := log.New(ioutil.Discard, "", 0)
+	client := geoapi.NewClient("user", "pass", geoapi.RetryPolicy{}, geoapi.NewBreaker(100, time.Minute), nil)
+	keys := auth.NewStore(nil, func([]auth.Key) error { return nil }, logger)
+	t.Cleanup(keys.Close)
+	keys.AddStatic("admin", adminKey, []string{auth.ScopeReadLive, auth.ScopeReadHistory, auth.ScopeAdmin})
+	keys.AddStatic("live", liveKey, []string{auth.ScopeReadLive})
+	broker := stream.NewBroker()
+	hub := stream.NewHub(logger)
+	t.Cleanup(broker.Close)
+	t.Cleanup(hub.Close)
+	var systems []models.System
+	for _, id := range []string{systemOK, systemEmpty, systemUnknown, systemFailing} {
+		systems = append(systems, models.System{ID: id, Name: "Test " + id, Geo: client})
+	}
+	return &models.Env{
+		Config:     models.Config{GeoSystemID: systemOK, CalorificValue: 39.5},
+		Logger:     logger,
+		Geo:        client,
+		Systems:    systems,
+		Snapshots:  snapshot.New(0),
+		Backfill:   stubBackfill{},
+		History:    stubHistory{},
+		LiveStream: broker,
+		WebSocket:  hub,
+		Metrics:    metrics.NewExporter(),
+		Schema:     models.Schema{Version: models.SchemaV2, Currency: "GBP"},
+		EnableAPI:  true,
+		Keys:       keys,
+		CORS:       models.CORSPolicy{AllowedOrigins: []string{"https://app.example.com"}, AllowedHeaders: []string{"X-Api-Key"}},
+	}
+}
+
+// newTestRouter returns the router serving env.
+func newTestRouter(env *models.Env) *mux.Router {
+	r := mux.NewRouter()
+	Initialize(r, env)
+	return r
+}
+
+func TestRoutes(t *testing.T) {
+	createKey := func(env *models.Env) {
+		_, _, err := env.Keys.Create("client", []string{auth.ScopeReadLive})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		method string
+		path   string
+		key    string
+		header http.Header
+		body   string
+		// setup changes the env before the request is made
+		setup  func(env *models.Env)
+		status int
+	}{
+		// Public routes
+		{method: "GET", path: "/api/status", status: 200},
+		{method: "GET", path: "/api/v1/openapi.json", status: 200},
+
+		// Metrics
+		{method: "GET", path: "/metrics", key: liveKey, setup: func(env *models.Env) {
+			env.Metrics.Update(systemOK, models.SourceLive, []metrics.Sample{{Name: "geo_live_power_watts", Value: 350}})
+		}, status: 200},
+		{method: "GET", path: "/metrics", status: 401},
+
+		// Deprecated beta routes
+		{method: "GET", path: "/api/beta/currentusage", key: adminKey, status: 200},
+		{method: "GET", path: "/api/beta/meterreadings", key: adminKey, status: 200},
+		{method: "GET", path: "/api/beta/live", key: adminKey, status: 200},
+		{method: "GET", path: "/api/beta/periodic", key: adminKey, status: 200},
+		{method: "GET", path: "/api/beta/backfill", key: adminKey, status: 200},
+		{method: "POST", path: "/api/beta/backfill?from=2021-01-01", key: adminKey, status: 202},
+		{method: "GET", path: "/api/beta/currentusage", status: 401},
+		{method: "GET", path: "/api/beta/backfill", key: liveKey, status: 403},
+		{method: "POST", path: "/api/beta/backfill?from=2021-01-01", key: liveKey, status: 403},
+		{method: "PUT", path: "/api/beta/live", key: adminKey, status: 405},
+
+		// Live routes
+		{method: "GET", path: "/api/v1/currentusage", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/meterreadings", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/live", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/periodic", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/bill", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/tariffs", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/costs", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/costs?duration=YEAR", key: liveKey, status: 400},
+		{method: "GET", path: "/api/v1/systems", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/systems/" + systemOK + "/currentusage", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/systems/" + systemOK + "/meterreadings", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/systems/" + systemOK + "/live", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/systems/" + systemOK + "/periodic", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/systems/" + systemOK + "/bill", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/systems/" + systemOK + "/tariffs", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/systems/" + systemOK + "/costs", key: liveKey, status: 200},
+		{method: "GET", path: "/api/v1/systems/" + systemEmpty + "/currentusage", key: liveKey, status: 204},
+		{method: "GET", path: "/api/v1/systems/" + systemEmpty + "/meterreadings", key: liveKey, status: 204},
+		{method: "GET", path: "/api/v1/systems/nope/currentusage", key: liveKey, status: 404},
+		{method: "GET", path: "/api/v1/systems/nope/periodic", key: liveKey, status: 404},
+		{method: "GET", path: "/api/v1/systems/" + systemUnknown + "/live", key: liveKey, status: 502},
+		{method: "GET", path: "/api/v1/systems/" + systemUnknown + "/bill", key: liveKey, status: 502},
+		{method: "GET", path: "/api/v1/systems/" + systemFailing + "/live", key: liveKey, status: 503},
+		{method: "GET", path: "/api/v1/systems/" + systemFailing + "/costs", key: liveKey, status: 503},
+		{method: "GET", path: "/api/v1/stream/live?system=nope", key: liveKey, status: 404},
+		{method: "GET", path: "/api/v1/stream/live", key: liveKey, setup: func(env *models.Env) { env.LiveStream = nil }, status: 503},
+		{method: "GET", path: "/api/v1/stream/live", status: 401},
+		{method: "GET", path: "/api/v1/ws", status: 401},
+		{method: "GET", path: "/api/v1/ws", key: liveKey, status: 400},
+		{method: "GET", path: "/api/v1/ws", key: liveKey, setup: func(env *models.Env) { env.WebSocket = nil }, status: 503},
+
+		// History routes
+		{method: "GET", path: "/api/v1/history/power", key: adminKey, status: 200},
+		{method: "GET", path: "/api/v1/history/energy", key: adminKey, status: 200},
+		{method: "GET", path: "/api/v1/systems/" + systemOK + "/history/power", key: adminKey, status: 200},
+		{method: "GET", path: "/api/v1/systems/" + systemOK + "/history/energy", key: adminKey, status: 200},
+		{method: "GET", path: "/api/v1/systems/nope/history/power", key: adminKey, status: 404},
+		{method: "GET", path: "/api/v1/history/power?from=tomorrow", key: adminKey, status: 400},
+		{method: "GET", path: "/api/v1/history/energy", key: adminKey, setup: func(env *models.Env) { env.History = nil }, status: 503},
+		{method: "GET", path: "/api/v1/history/power", key: liveKey, status: 403},
+		{method: "GET", path: "/api/v1/backfill", key: adminKey, status: 200},
+		{method: "GET", path: "/api/v1/backfill", key: liveKey, status: 403},
+
+		// Admin routes
+		{method: "POST", path: "/api/v1/backfill?from=2021-01-01&to=2021-01-02", key: adminKey, status: 202},
+		{method: "POST", path: "/api/v1/backfill?from=yesterday", key: adminKey, status: 400},
+		{method: "POST", path: "/api/v1/backfill?from=2021-01-01", key: adminKey, setup: func(env *models.Env) { env.Backfill = nil }, status: 503},
+		{method: "POST", path: "/api/v1/backfill?from=2021-01-01", key: liveKey, status: 403},
+		{method: "GET", path: "/api/v1/keys", key: adminKey, status: 200},
+		{method: "GET", path: "/api/v1/keys", key: liveKey, status: 403},
+		{method: "POST", path: "/api/v1/keys", key: adminKey, body: `{"name":"client","scopes":["read-live"]}`, status: 201},
+		{method: "POST", path: "/api/v1/keys", key: adminKey, body: `{"name":"client","scopes":["read-live"]}`, setup: createKey, status: 409},
+		{method: "POST", path: "/api/v1/keys", key: adminKey, body: `{"name":"client","scopes":["write"]}`, status: 400},
+		{method: "POST", path: "/api/v1/keys", key: adminKey, body: `not json`, status: 400},
+		{method: "POST", path: "/api/v1/keys/client/rotate", key: adminKey, setup: createKey, status: 200},
+		{method: "POST", path: "/api/v1/keys/admin/rotate", key: adminKey, status: 409},
+		{method: "DELETE", path: "/api/v1/keys/client", key: adminKey, setup: createKey, status: 204},
+		{method: "DELETE", path: "/api/v1/keys/nope", key: adminKey, status: 404},
+		{method: "DELETE", path: "/api/v1/keys/client", key: liveKey, setup: createKey, status: 403},
+
+		// Authentication
+		{method: "GET", path: "/api/v1/live", status: 401},
+		{method: "GET", path: "/api/v1/live", key: "wrong-key", status: 401},
+		{method: "GET", path: "/api/v1/keys", status: 401},
+
+		// Not found and method not allowed
+		{method: "GET", path: "/api/nope", status: 404},
+		{method: "GET", path: "/api/v1/nope", status: 404},
+		{method: "GET", path: "/api/v1/systems/" + systemOK + "/nope", key: liveKey, status: 404},
+		{method: "POST", path: "/api/v1/live", key: liveKey, status: 405},
+		{method: "PUT", path: "/api/v1/keys", key: adminKey, status: 405},
+		{method: "POST", path: "/api/status", status: 405},
+
+		// CORS preflight requests
+		{method: "OPTIONS", path: "/api/v1/live", header: http.Header{"Origin": {"https://app.example.com"}, "Access-Control-Request-Method": {"GET"}}, status: 204},
+		{method: "OPTIONS", path: "/api/v1/live", header: http.Header{"Origin": {"https://evil.example.com"}, "Access-Control-Request-Method": {"GET"}}, status: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			env := newTestEnv(t)
+			if tt.setup != nil {
+				tt.setup(env)
+			}
+			router := newTestRouter(env)
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			if tt.key != "" {
+				req.Header.Set("X-Api-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// Check status and whether there is a body
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusNoContent {
+				if w.Body.Len() > 0 {
+					t.Errorf("expected no body, got %s", w.Body)
+				}
+				return
+			}
+			if w.Body.Len() == 0 {
+				t.Fatal("expected a body")
+			}
+			if tt.status < 400 {
+				return
+			}
+
+			// Check errors are in the error envelope
+			var resp models.ErrorResponse
+			err := json.Unmarshal(w.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatalf("invalid error body %s: %s", w.Body, err)
+			}
+			want := models.NewErrorResponse(tt.status, resp.Error.Message)
+			if resp != want || resp.Error.Message == "" {
+				t.Errorf("error = %+v, want %+v with a message", resp.Error, want.Error)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 response without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestSnapshotConditionalRequests(t *testing.T) {
+	env := newTestEnv(t)
+	router := newTestRouter(env)
+	get := func(path string, ifNoneMatch string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Api-Key", liveKey)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The first request fetches the snapshot, returning its caching headers
+	w := get("/api/v1/currentusage", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Header().Get("Last-Modified") == "" || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("status = %d with headers %v, want 200 with caching headers", w.Code, w.Header())
+	}
+
+	tests := []struct {
+		path        string
+		ifNoneMatch string
+		status      int
+	}{
+		{path: "/api/v1/currentusage", ifNoneMatch: etag, status: http.StatusNotModified},
+		{path: "/api/v1/currentusage", ifNoneMatch: `"other", W/` + etag, status: http.StatusNotModified},
+		{path: "/api/v1/currentusage", ifNoneMatch: "*", status: http.StatusNotModified},
+		{path: "/api/v1/currentusage", ifNoneMatch: `"other"`, status: http.StatusOK},
+		// The raw live data is the same snapshot, while the periodic data has its own entity tag
+		{path: "/api/v1/live", ifNoneMatch: etag, status: http.StatusNotModified},
+		{path: "/api/v1/meterreadings", ifNoneMatch: etag, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := get(tt.path, tt.ifNoneMatch)
+		if w.Code != tt.status {
+			t.Errorf("%s with If-None-Match %s: status = %d, want %d", tt.path, tt.ifNoneMatch, w.Code, tt.status)
+		}
+		if tt.status == http.StatusNotModified && (w.Body.Len() > 0 || w.Header().Get("ETag") != etag) {
+			t.Errorf("%s with If-None-Match %s: 304 with body %q and ETag %s", tt.path, tt.ifNoneMatch, w.Body, w.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestUnmatchedRoutesRateLimited(t *testing.T) {
+	env := newTestEnv(t)
+	env.IPRateLimit = ratelimit.New(1, 1)
+	router := newTestRouter(env)
+	request := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("X-Api-Key", liveKey)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Requests no route matches share the client's limit with every other request
+	if w := request(http.MethodGet, "/api/nope"); w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Code)
+	}
+	for _, path := range []string{"/api/nope", "/api/v1/nope"} {
+		if w := request(http.MethodGet, path); w.Code != http.StatusTooManyRequests {
+			t.Errorf("status for %s = %d, want 429", path, w.Code)
+		}
+	}
+	w := request(http.MethodPost, "/api/v1/live")
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status for a method not allowed = %d, want 429", w.Code)
+	}
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the allowed origin", origin)
+	}
+}
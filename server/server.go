@@ -2,28 +2,214 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
-	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/olivercullimore/geo-energy-data-client"
+	"github.com/olivercullimore/geo-energy-data/server/auth"
+	"github.com/olivercullimore/geo-energy-data/server/backfill"
+	"github.com/olivercullimore/geo-energy-data/server/geoapi"
+	"github.com/olivercullimore/geo-energy-data/server/metrics"
 	"github.com/olivercullimore/geo-energy-data/server/models"
+	"github.com/olivercullimore/geo-energy-data/server/mqtt"
+	"github.com/olivercullimore/geo-energy-data/server/ratelimit"
 	"github.com/olivercullimore/geo-energy-data/server/routes"
+	"github.com/olivercullimore/geo-energy-data/server/sinks"
+	"github.com/olivercullimore/geo-energy-data/server/snapshot"
+	"github.com/olivercullimore/geo-energy-data/server/spool"
+	"github.com/olivercullimore/geo-energy-data/server/store"
+	"github.com/olivercullimore/geo-energy-data/server/stream"
+	"github.com/olivercullimore/geo-energy-data/server/tlsreload"
 	"github.com/olivercullimore/go-utils/configfile"
 	envs "github.com/olivercullimore/go-utils/env"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"path"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
+const (
+	// startupRetryDelay is how long to wait before retrying startup requests to the geo API
+	startupRetryDelay = 30 * time.Second
+	// snapshotMinRefresh is how often API requests can force meter data to be fetched again
+	snapshotMinRefresh = 10 * time.Second
+)
+
+// app holds everything set up from the environment variables and config file.
+type app struct {
+	env              *models.Env
+	influxDB         *sinks.InfluxDB
+	broker           *stream.Broker
+	hub              *stream.Hub
+	sinks            []sinks.Sink
+	configDir        string
+	httpPort         string
+	unixSocket       string
+	unixSocketMode   os.FileMode
+	tlsConfig        *tls.Config
+	liveInterval     int
+	periodicInterval int
+}
+
 func Run() {
+	// Set up from environment variables and config
+	a := setup()
+	env := a.env
+
+	// Listen for interrupts
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// Initialize get meter data periodic tasks for each system, which also keep the API's
+	// snapshots up to date
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	var dispatcher *sinks.Dispatcher
+	if len(a.sinks) > 0 || env.EnableAPI {
+		dispatcher = sinks.NewDispatcher(a.sinks, env.DebugMode, env.Logger)
+		env.Logger.Println("Starting schedulers")
+		for _, system := range env.Systems {
+			tick := time.NewTicker(time.Second * time.Duration(a.liveInterval))
+			tick2 := time.NewTicker(time.Second * time.Duration(a.periodicInterval))
+			wg.Add(1)
+			go func(system models.System) {
+				defer wg.Done()
+				scheduler(tick, tick2, done, dispatcher, a.hub, env.Snapshots, system, env.Config.CalorificValue, env.DebugMode, env.Logger)
+			}(system)
+		}
+	}
+
+	// Initialize API
+	var s *http.Server
+	if env.EnableAPI {
+		// Initialize router
+		r := mux.NewRouter().StrictSlash(true)
+
+		// Initialize routes
+		routes.Initialize(r, env)
+
+		// Listen on the Unix socket if set, otherwise the HTTP port
+		listener, err := listen(a)
+		if err != nil {
+			env.Logger.Fatalf("Error starting server: %s\n", err)
+		}
+
+		// Initialize http server
+		env.Logger.Println("Starting API server on", listener.Addr())
+		s = newServer(r, a.tlsConfig, env.Logger)
+		// Run http server
+		go func() {
+			var err error
+			if a.tlsConfig != nil {
+				err = s.ServeTLS(listener, "", "")
+			} else {
+				err = s.Serve(listener)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				env.Logger.Printf("Error starting server: %s\n", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// Wait for an interrupt
+	sig := <-sigChan
+	env.Logger.Println("Got signal:", sig)
+
+	// Stop schedulers and wait for any running fetches to finish
+	close(done)
+	wg.Wait()
+
+	// Shutdown http server while the sinks are still open for requests in progress, ending streams
+	// first as they would otherwise hold up the shutdown
+	if s != nil {
+		if a.broker != nil {
+			a.broker.Close()
+		}
+		if a.hub != nil {
+			a.hub.Close()
+		}
+		tc, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		err := s.Shutdown(tc)
+		if err != nil {
+			env.Logger.Println(err)
+		} else {
+			env.Logger.Println("Shutdown Server")
+		}
+	}
+
+	// Stop any backfill, which writes to InfluxDB, after the day it's on
+	if env.Backfill != nil {
+		env.Backfill.Stop()
+	}
+
+	// Write any queued readings and close sinks
+	if dispatcher != nil {
+		dispatcher.Close()
+	} else {
+		for _, sink := range a.sinks {
+			sink.Close()
+		}
+	}
+
+	// Save when API keys were last used
+	env.Keys.Close()
+}
+
+// newServer returns the API server for handler, serving TLS with tlsConfig if it isn't nil. Streams
+// extend their own write deadlines past WriteTimeout, over both HTTP/1.1 and HTTP/2.
+func newServer(handler http.Handler, tlsConfig *tls.Config, logger *log.Logger) *http.Server {
+	return &http.Server{
+		Handler:      handler,           // set the default handler
+		TLSConfig:    tlsConfig,         // serve TLS if enabled
+		ErrorLog:     logger,            // set the logger for the server
+		IdleTimeout:  120 * time.Second, // max time to wait for the next request on a keep-alive connection
+		ReadTimeout:  5 * time.Second,   // max time to read request from the client
+		WriteTimeout: 10 * time.Second,  // max time to write response to the client
+	}
+}
 
+// listen returns a listener on the Unix socket if it's set, replacing any stale socket, otherwise
+// on the HTTP port.
+func listen(a *app) (net.Listener, error) {
+	if a.unixSocket == "" {
+		return net.Listen("tcp", ":"+a.httpPort)
+	}
+	// Remove socket left by an earlier run
+	info, err := os.Lstat(a.unixSocket)
+	if err == nil && info.Mode()&os.ModeSocket != 0 {
+		err = os.Remove(a.unixSocket)
+		if err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", a.unixSocket)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(a.unixSocket, a.unixSocketMode)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// setup loads and validates the environment variables and config file, exiting if they are
+// invalid, and initialises everything that depends on them.
+func setup() *app {
 	// Initialize logger
 	logger := log.New(os.Stdout, "app: ", log.LstdFlags)
 
@@ -51,11 +237,36 @@ func Run() {
 	configFile := checkConfig("CONFIG_FILE", "/config/config.json", "config file", "", logger)
 	enableAPIStr := checkConfig("ENABLE_API", "false", "Enable API", "", logger)
 	enableInfluxDBStr := checkConfig("ENABLE_INFLUXDB", "true", "Enable InfluxDB", "", logger)
+	enableMetricsStr := checkConfig("ENABLE_METRICS", "false", "Enable metrics", "", logger)
+	enableMQTTStr := checkConfig("ENABLE_MQTT", "false", "Enable MQTT", "", logger)
+	enableStdoutSinkStr := checkConfig("ENABLE_STDOUT_SINK", "false", "Enable stdout sink", "", logger)
+	enableFileSinkStr := checkConfig("ENABLE_FILE_SINK", "false", "Enable file sink", "", logger)
+	enableStoreStr := checkConfig("ENABLE_STORE", "false", "Enable store", "", logger)
+	enableStreamStr := checkConfig("ENABLE_STREAM", "false", "Enable stream", "", logger)
+	enableWebSocketStr := checkConfig("ENABLE_WEBSOCKET", "false", "Enable WebSocket", "", logger)
+	enableJWTStr := checkConfig("ENABLE_JWT", "false", "Enable JWT", "", logger)
+	enableTLSStr := checkConfig("ENABLE_TLS", "false", "Enable TLS", "", logger)
 	geoUser := checkConfig("GEO_USER", "", "geo user", "", logger)
 	geoPass := checkConfig("GEO_PASS", "", "geo pass", "", logger)
 	calorificValueStr := checkConfig("CALORIFIC_VALUE", "39.5", "calorific value", "", logger)
+	currency := checkConfig("CURRENCY", "GBP", "currency", "", logger)
+	schemaVersionStr := checkConfig("SCHEMA_VERSION", "1", "schema version", "numeric", logger)
+	geoMaxRetriesStr := checkConfig("GEO_MAX_RETRIES", "3", "geo max retries", "numeric", logger)
+	geoBreakerThresholdStr := checkConfig("GEO_BREAKER_THRESHOLD", "5", "geo breaker threshold", "numeric", logger)
+	geoBreakerCooldownStr := checkConfig("GEO_BREAKER_COOLDOWN", "300", "geo breaker cooldown", "numeric", logger)
+	geoCallBudgetStr := checkConfig("GEO_CALL_BUDGET", "60", "geo call budget", "numeric", logger)
 	httpPort := ""
+	unixSocket := ""
+	unixSocketMode := "0660"
 	apiKey := ""
+	rateLimit := "0"
+	rateLimitIP := "0"
+	rateLimitBurst := "0"
+	trustedProxies := ""
+	corsAllowedOrigins := "*"
+	corsAllowedHeaders := ""
+	corsAllowCredentials := "false"
+	corsMaxAge := "0"
 	liveDataFetchInterval := "30"
 	periodicDataFetchInterval := "300"
 	influxDBHost := ""
@@ -63,25 +274,126 @@ func Run() {
 	influxDBOrg := ""
 	influxDBBucket := ""
 	influxDBToken := ""
+	spoolMaxSize := "100"
+	spoolMaxAge := "168"
+	fileSinkPath := ""
+	storeRetention := "365"
+	storeRawRetention := "7"
+	storeDownsampleInterval := "300"
 
 	// API enabled?
 	enableAPI := false
 	if enableAPIStr == "true" {
 		enableAPI = true
 		httpPort = checkConfig("HTTP_PORT", "80", "HTTP port", "numeric", logger)
+		unixSocket = checkConfig("UNIX_SOCKET", "", "Unix socket", "optional", logger)
+		if unixSocket != "" {
+			unixSocketMode = checkConfig("UNIX_SOCKET_MODE", "0660", "Unix socket mode", "octal", logger)
+		}
 		apiKey = checkConfig("API_KEY", "", "API key", "", logger)
+		rateLimit = checkConfig("RATE_LIMIT", "60", "rate limit", "numeric", logger)
+		rateLimitIP = checkConfig("RATE_LIMIT_IP", "120", "rate limit IP", "numeric", logger)
+		rateLimitBurst = checkConfig("RATE_LIMIT_BURST", "20", "rate limit burst", "numeric", logger)
+		trustedProxies = checkConfig("TRUSTED_PROXIES", "", "trusted proxies", "optional", logger)
+		corsAllowedOrigins = checkConfig("CORS_ALLOWED_ORIGINS", "*", "CORS allowed origins", "", logger)
+		corsAllowedHeaders = checkConfig("CORS_ALLOWED_HEADERS", "Authorization,Content-Type,If-None-Match,Last-Event-ID,X-Api-Key", "CORS allowed headers", "", logger)
+		corsAllowCredentials = checkConfig("CORS_ALLOW_CREDENTIALS", "false", "CORS allow credentials", "", logger)
+		corsMaxAge = checkConfig("CORS_MAX_AGE", "600", "CORS max age", "numeric", logger)
 	}
 	// InfluxDB enabled?
 	enableInfluxDB := false
 	if enableInfluxDBStr == "true" {
 		enableInfluxDB = true
-		liveDataFetchInterval = checkConfig("LIVE_DATA_FETCH_INTERVAL", "10", "live data fetch interval", "numeric", logger)
-		periodicDataFetchInterval = checkConfig("PERIODIC_DATA_FETCH_INTERVAL", "300", "periodic data fetch interval", "numeric", logger)
 		influxDBHost = checkConfig("INFLUXDB_HOST", "", "InfluxDB host", "url", logger)
 		influxDBPort = checkConfig("INFLUXDB_PORT", "8086", "InfluxDB port", "numeric", logger)
 		influxDBOrg = checkConfig("INFLUXDB_ORG", "", "InfluxDB organization", "", logger)
 		influxDBBucket = checkConfig("INFLUXDB_BUCKET", "", "InfluxDB bucket", "", logger)
 		influxDBToken = checkConfig("INFLUXDB_TOKEN", "", "InfluxDB token", "", logger)
+		spoolMaxSize = checkConfig("SPOOL_MAX_SIZE", "100", "spool max size", "numeric", logger)
+		spoolMaxAge = checkConfig("SPOOL_MAX_AGE", "168", "spool max age", "numeric", logger)
+	}
+	// Metrics enabled?
+	enableMetrics := false
+	if enableMetricsStr == "true" && enableAPI {
+		enableMetrics = true
+	}
+	// Stream enabled?
+	enableStream := false
+	if enableStreamStr == "true" && enableAPI {
+		enableStream = true
+	}
+	// WebSocket enabled?
+	enableWebSocket := false
+	if enableWebSocketStr == "true" && enableAPI {
+		enableWebSocket = true
+	}
+	// JWT enabled?
+	enableJWT := false
+	jwtConfig := auth.JWTConfig{}
+	if enableJWTStr == "true" && enableAPI {
+		enableJWT = true
+		jwtConfig.JWKS = checkConfig("JWT_JWKS", "", "JWT JWKS", "", logger)
+		jwtConfig.Issuer = checkConfig("JWT_ISSUER", "", "JWT issuer", "", logger)
+		jwtConfig.Audience = checkConfig("JWT_AUDIENCE", "", "JWT audience", "", logger)
+		jwtConfig.ScopesClaim = checkConfig("JWT_SCOPES_CLAIM", "scope", "JWT scopes claim", "", logger)
+		jwtConfig.ScopeMap, err = auth.ParseScopeMap(checkConfig("JWT_SCOPE_MAP", "", "JWT scope map", "optional", logger))
+		if err != nil {
+			logger.Fatalf("Invalid JWT scope map value: %s", err)
+		}
+	}
+	// TLS enabled?
+	enableTLS := false
+	tlsCertFile := ""
+	tlsKeyFile := ""
+	tlsClientCAFile := ""
+	tlsClientAuth := "require"
+	if enableTLSStr == "true" && enableAPI {
+		enableTLS = true
+		tlsCertFile = checkConfig("TLS_CERT_FILE", "", "TLS cert file", "", logger)
+		tlsKeyFile = checkConfig("TLS_KEY_FILE", "", "TLS key file", "", logger)
+		tlsClientCAFile = checkConfig("TLS_CLIENT_CA_FILE", "", "TLS client CA file", "optional", logger)
+		if tlsClientCAFile != "" {
+			tlsClientAuth = checkConfig("TLS_CLIENT_AUTH", "require", "TLS client auth", "", logger)
+			if tlsClientAuth != "require" && tlsClientAuth != "optional" {
+				logger.Fatalf("Invalid TLS client auth value")
+			}
+		}
+	}
+	// MQTT enabled?
+	enableMQTT := false
+	mqttConfig := mqtt.Config{}
+	if enableMQTTStr == "true" {
+		enableMQTT = true
+		mqttConfig.Broker = checkConfig("MQTT_BROKER", "", "MQTT broker", "url", logger)
+		mqttConfig.ClientID = checkConfig("MQTT_CLIENT_ID", "geo-energy-data", "MQTT client ID", "", logger)
+		mqttConfig.Username = checkConfig("MQTT_USER", "", "MQTT user", "optional", logger)
+		mqttConfig.Password = checkConfig("MQTT_PASS", "", "MQTT pass", "optional", logger)
+		mqttConfig.TopicPrefix = checkConfig("MQTT_TOPIC_PREFIX", "geo-energy-data", "MQTT topic prefix", "", logger)
+		mqttConfig.DiscoveryPrefix = checkConfig("MQTT_DISCOVERY_PREFIX", "homeassistant", "MQTT discovery prefix", "", logger)
+		if mqttConfig.DiscoveryPrefix == "false" {
+			mqttConfig.DiscoveryPrefix = ""
+		}
+		mqttConfig.Currency = currency
+	}
+	// File sink enabled?
+	enableFileSink := false
+	if enableFileSinkStr == "true" {
+		enableFileSink = true
+		fileSinkPath = checkConfig("FILE_SINK_PATH", filepath.Join(filepath.Dir(configFile), "readings.jsonl"), "file sink path", "", logger)
+	}
+	enableStdoutSink := enableStdoutSinkStr == "true"
+	// Store enabled?
+	enableStore := false
+	if enableStoreStr == "true" {
+		enableStore = true
+		storeRetention = checkConfig("STORE_RETENTION", "365", "store retention", "numeric", logger)
+		storeRawRetention = checkConfig("STORE_RAW_RETENTION", "7", "store raw retention", "numeric", logger)
+		storeDownsampleInterval = checkConfig("STORE_DOWNSAMPLE_INTERVAL", "300", "store downsample interval", "numeric", logger)
+	}
+	// Fetch intervals needed?
+	if enableAPI || enableInfluxDB || enableMetrics || enableStream || enableWebSocket || enableMQTT || enableStdoutSink || enableFileSink || enableStore {
+		liveDataFetchInterval = checkConfig("LIVE_DATA_FETCH_INTERVAL", "10", "live data fetch interval", "numeric", logger)
+		periodicDataFetchInterval = checkConfig("PERIODIC_DATA_FETCH_INTERVAL", "300", "periodic data fetch interval", "numeric", logger)
 	}
 
 	// Load config
@@ -91,50 +403,138 @@ func Run() {
 		logger.Println("Loaded config")
 	}
 
-	// Check if system ID is set
-	if config.GeoSystemID == "" && geoUser != "" && geoPass != "" {
-		// Get an access token
-		accessToken, err := geo.GetAccessToken(geoUser, geoPass)
-		checkErr(err, debugMode, logger)
-		if accessToken == "" {
-			logger.Fatalf("Unable to retrieve an access token. Please check your login details are correct\n")
+	// Initialise geo API retry policy and circuit breaker, shared by all accounts
+	geoMaxRetries, err := strconv.Atoi(geoMaxRetriesStr)
+	checkErr(err, debugMode, logger)
+	geoBreakerThreshold, err := strconv.Atoi(geoBreakerThresholdStr)
+	checkErr(err, debugMode, logger)
+	geoBreakerCooldown, err := strconv.Atoi(geoBreakerCooldownStr)
+	checkErr(err, debugMode, logger)
+	geoCallBudget, err := strconv.Atoi(geoCallBudgetStr)
+	checkErr(err, debugMode, logger)
+	budget := geoapi.NewBudget(geoCallBudget)
+	retryPolicy := geoapi.DefaultRetryPolicy
+	retryPolicy.MaxRetries = geoMaxRetries
+	breaker := geoapi.NewBreaker(geoBreakerThreshold, time.Second*time.Duration(geoBreakerCooldown))
+	var broker *stream.Broker
+	var hub *stream.Hub
+	breaker.OnStateChange = func(from, to geoapi.BreakerState) {
+		logger.Printf("geo API circuit breaker changed from %s to %s\n", from, to)
+		hub.Alert(models.Alert{Type: "breaker_" + strings.ReplaceAll(to.String(), "-", "_"), Message: fmt.Sprintf("geo API circuit breaker changed from %s to %s", from, to)})
+	}
+
+	// Add the account set by the environment variables, moving the system ID saved by earlier
+	// versions to it
+	configChanged := false
+	accountIndex := -1
+	for i, account := range config.Accounts {
+		if account.User == geoUser {
+			accountIndex = i
+			break
+		}
+	}
+	if accountIndex < 0 {
+		account := models.AccountConfig{User: geoUser}
+		if config.GeoSystemID != "" {
+			account.Systems = []models.SystemConfig{{ID: config.GeoSystemID}}
 		}
+		config.Accounts = append([]models.AccountConfig{account}, config.Accounts...)
+		accountIndex = 0
+		configChanged = true
+	}
 
-		// Get device data to get the system ID
-		deviceData, err := geo.GetDeviceData(accessToken)
+	// Log in to each account and get its systems
+	var geoClient *geoapi.Client
+	var systems []models.System
+	systemIDs := map[string]bool{}
+	for i := range config.Accounts {
+		account := &config.Accounts[i]
+		accountPass := account.Pass
+		if i == accountIndex {
+			accountPass = geoPass
+		}
+		if accountPass == "" {
+			logger.Fatalf("No password set for geo account %s\n", account.User)
+		}
+		client := geoapi.NewClient(account.User, accountPass, retryPolicy, breaker, budget)
+		if i == accountIndex {
+			geoClient = client
+		}
+
+		// Check login details are valid
+		var accessToken string
+		err = retryStartup("login as "+account.User, logger, func() error {
+			accessToken, err = client.Login()
+			return err
+		})
 		checkErr(err, debugMode, logger)
-		if debugMode {
-			logger.Println(deviceData)
+		if accessToken == "" {
+			logger.Fatalf("Unable to retrieve an access token for %s. Please check your login details are correct\n", account.User)
 		}
 
-		// Set system ID and save config
-		if len(deviceData.SystemDetails) > 0 && deviceData.SystemDetails[0].SystemID != "" {
-			config.GeoSystemID = deviceData.SystemDetails[0].SystemID
-			logger.Println("Saving config")
-			err = configfile.Save(configFile, &config)
+		// Get device data to get the system IDs if none are set
+		if len(account.Systems) == 0 {
+			var deviceData geo.DeviceData
+			err = retryStartup("get device data for "+account.User, logger, func() error {
+				deviceData, err = client.GetDeviceData()
+				return err
+			})
 			checkErr(err, debugMode, logger)
-		} else {
 			if debugMode {
-				logger.Fatalf("No system ID found in: %v\n", deviceData)
-			} else {
-				logger.Fatalf("No system ID found\n")
+				logger.Println(deviceData)
+			}
+			for _, details := range deviceData.SystemDetails {
+				if details.SystemID != "" {
+					account.Systems = append(account.Systems, models.SystemConfig{ID: details.SystemID, Name: details.Name})
+				}
 			}
+			if len(account.Systems) == 0 {
+				if debugMode {
+					logger.Fatalf("No system ID found for %s in: %v\n", account.User, deviceData)
+				} else {
+					logger.Fatalf("No system ID found for %s\n", account.User)
+				}
+			}
+			configChanged = true
 		}
-	} else {
-		// Check login details are still valid
-		authData, err := geo.Login(geoUser, geoPass)
-		checkErr(err, debugMode, logger)
-		if authData.AccessToken == "" {
-			logger.Fatalf("Unable to retrieve an access token. Please check your login details are correct\n")
+
+		// Add systems, skipping any already added by another account
+		for _, system := range account.Systems {
+			if systemIDs[system.ID] {
+				logger.Printf("Skipping system %s of %s as it is already set for another account\n", system.ID, account.User)
+				continue
+			}
+			systemIDs[system.ID] = true
+			systems = append(systems, models.System{ID: system.ID, Name: system.Name, Geo: client})
 		}
 	}
 
+	// Use the first system of the account set by the environment variables by default
+	defaultSystemID := config.Accounts[accountIndex].Systems[0].ID
+	if config.GeoSystemID != defaultSystemID {
+		config.GeoSystemID = defaultSystemID
+		configChanged = true
+	}
+	if configChanged {
+		logger.Println("Saving config")
+		err = configfile.Save(configFile, &config)
+		checkErr(err, debugMode, logger)
+	}
+
 	// Convert fetch intervals to time period intervals
 	liveInterval, err := strconv.Atoi(liveDataFetchInterval)
 	checkErr(err, debugMode, logger)
 	periodicInterval, err := strconv.Atoi(periodicDataFetchInterval)
 	checkErr(err, debugMode, logger)
 
+	// Warn if the schedulers alone would use up the geo API call budget
+	if budget != nil && liveInterval > 0 && periodicInterval > 0 {
+		scheduledCalls := float64(len(systems)) * (60/float64(liveInterval) + 60/float64(periodicInterval))
+		if scheduledCalls > float64(geoCallBudget) {
+			logger.Printf("Fetching data for %d systems needs %.1f geo API calls a minute, more than the call budget of %d, so some fetches will be skipped\n", len(systems), scheduledCalls, geoCallBudget)
+		}
+	}
+
 	// Convert calorific value to float and save config
 	calorificValue, err := strconv.ParseFloat(calorificValueStr, 64)
 	checkErr(err, debugMode, logger)
@@ -145,79 +545,200 @@ func Run() {
 		checkErr(err, debugMode, logger)
 	}
 
+	// Get schema points are written with
+	schemaVersion, err := strconv.Atoi(schemaVersionStr)
+	checkErr(err, debugMode, logger)
+	if schemaVersion != models.SchemaV1 && schemaVersion != models.SchemaV2 {
+		logger.Fatalf("Invalid schema version value")
+	}
+	schema := models.Schema{Version: schemaVersion, Currency: currency}
+
+	// Initialise InfluxDB sink with a spool for records that fail to write
+	var enabledSinks []sinks.Sink
+	var influxDB *sinks.InfluxDB
+	if enableInfluxDB {
+		maxSize, err := strconv.ParseInt(spoolMaxSize, 10, 64)
+		checkErr(err, debugMode, logger)
+		maxAge, err := strconv.Atoi(spoolMaxAge)
+		checkErr(err, debugMode, logger)
+		writeSpool, err := spool.New(filepath.Join(filepath.Dir(configFile), "spool"), maxSize*1024*1024, time.Hour*time.Duration(maxAge), logger)
+		checkErr(err, debugMode, logger)
+		if n := writeSpool.Len(); n > 0 {
+			logger.Printf("Found %d spooled batches to write to InfluxDB\n", n)
+		}
+		influxDB = sinks.NewInfluxDB(influxDBHost, influxDBPort, influxDBToken, influxDBOrg, influxDBBucket, schema, writeSpool, logger)
+		enabledSinks = append(enabledSinks, influxDB)
+	}
+
+	// Initialise store, always using the latest schema as it isn't read by existing dashboards
+	var localStore *store.Store
+	if enableStore {
+		retention, err := strconv.Atoi(storeRetention)
+		checkErr(err, debugMode, logger)
+		rawRetention, err := strconv.Atoi(storeRawRetention)
+		checkErr(err, debugMode, logger)
+		downsampleInterval, err := strconv.Atoi(storeDownsampleInterval)
+		checkErr(err, debugMode, logger)
+		storeOptions := store.Options{
+			Retention:          24 * time.Hour * time.Duration(retention),
+			RawRetention:       24 * time.Hour * time.Duration(rawRetention),
+			DownsampleInterval: time.Second * time.Duration(downsampleInterval),
+		}
+		localStore, err = store.Open(filepath.Join(filepath.Dir(configFile), "store"), storeOptions, models.Schema{Version: models.SchemaV2, Currency: currency}, logger)
+		checkErr(err, debugMode, logger)
+		enabledSinks = append(enabledSinks, localStore)
+	}
+
+	// Initialise stdout and file sinks
+	if enableStdoutSink {
+		enabledSinks = append(enabledSinks, sinks.NewStdout())
+	}
+	if enableFileSink {
+		fileSink, err := sinks.NewFile(fileSinkPath)
+		checkErr(err, debugMode, logger)
+		enabledSinks = append(enabledSinks, fileSink)
+	}
+
+	// Initialise API key store, with the key set by the environment variables as an admin key
+	keys := auth.NewStore(config.APIKeys, func(apiKeys []auth.Key) error {
+		config.APIKeys = apiKeys
+		return configfile.Save(configFile, &config)
+	}, logger)
+	if apiKey != "" {
+		keys.AddStatic("API_KEY", apiKey, []string{auth.ScopeAdmin})
+	}
+
+	// Initialise JWT verifier, loading the JWKS now to report any problem with it early
+	var jwtVerifier *auth.JWTVerifier
+	if enableJWT {
+		jwtVerifier = auth.NewJWTVerifier(jwtConfig, logger)
+		err = jwtVerifier.Refresh()
+		if err != nil {
+			logger.Println(err)
+		}
+	}
+
+	// Initialise TLS config, reloading the certificate when it's renewed
+	var tlsConfig *tls.Config
+	if enableTLS {
+		reloader, err := tlsreload.New(tlsCertFile, tlsKeyFile, tlsClientCAFile, logger)
+		checkErr(err, debugMode, logger)
+		clientAuth := tls.RequireAndVerifyClientCert
+		if tlsClientAuth == "optional" {
+			clientAuth = tls.VerifyClientCertIfGiven
+		}
+		tlsConfig = reloader.Config(clientAuth)
+	}
+
+	// Check Unix socket mode only has permission bits
+	socketMode, err := strconv.ParseUint(unixSocketMode, 8, 32)
+	if err != nil || os.FileMode(socketMode)&^os.ModePerm != 0 {
+		logger.Fatalf("Invalid Unix socket mode value")
+	}
+
+	// Initialise API rate limits
+	rateLimitPerMinute, err := strconv.Atoi(rateLimit)
+	checkErr(err, debugMode, logger)
+	rateLimitIPPerMinute, err := strconv.Atoi(rateLimitIP)
+	checkErr(err, debugMode, logger)
+	rateLimitBurstSize, err := strconv.Atoi(rateLimitBurst)
+	checkErr(err, debugMode, logger)
+	trustedProxyNets, err := parseNetworks(trustedProxies)
+	if err != nil {
+		logger.Fatalf("Invalid trusted proxies: %s\n", err)
+	}
+
+	// Initialise CORS policy, checking the allowed origin patterns are valid
+	corsMaxAgeSeconds, err := strconv.Atoi(corsMaxAge)
+	checkErr(err, debugMode, logger)
+	corsPolicy := models.CORSPolicy{
+		AllowedOrigins:   splitList(corsAllowedOrigins),
+		AllowedHeaders:   splitList(corsAllowedHeaders),
+		AllowCredentials: corsAllowCredentials == "true",
+		MaxAge:           time.Second * time.Duration(corsMaxAgeSeconds),
+	}
+	for _, origin := range corsPolicy.AllowedOrigins {
+		_, err = path.Match(origin, "")
+		if err != nil {
+			logger.Fatalf("Invalid CORS allowed origin %q\n", origin)
+		}
+	}
+	// Credentials can't be allowed from any origin, as any site could then use them
+	if corsPolicy.AllowCredentials && corsPolicy.AllowsAnyOrigin() {
+		logger.Fatalf("CORS_ALLOW_CREDENTIALS can't be true when CORS_ALLOWED_ORIGINS is *")
+	}
+
 	// Initialise env
 	env := &models.Env{
 		Config:         config,
 		Logger:         logger,
 		GeoUser:        geoUser,
 		GeoPass:        geoPass,
-		APIKey:         apiKey,
+		Geo:            geoClient,
+		Systems:        systems,
+		Snapshots:      snapshot.New(snapshotMinRefresh),
+		Schema:         schema,
+		Keys:           keys,
+		JWT:            jwtVerifier,
+		KeyRateLimit:   ratelimit.New(rateLimitPerMinute, rateLimitBurstSize),
+		IPRateLimit:    ratelimit.New(rateLimitIPPerMinute, rateLimitBurstSize),
+		TrustedProxies: trustedProxyNets,
+		CORS:           corsPolicy,
 		EnableAPI:      enableAPI,
 		EnableInfluxDB: enableInfluxDB,
 		DebugMode:      debugMode,
 	}
 
-	// Check System ID is set
-	if config.GeoSystemID != "" {
-		// Initialize get meter data periodic tasks
-		if env.EnableInfluxDB {
-			tick := time.NewTicker(time.Second * time.Duration(liveInterval))
-			tick2 := time.NewTicker(time.Second * time.Duration(periodicInterval))
-			go func() {
-				done := make(chan bool)
-				env.Logger.Println("Starting schedulers")
-				go scheduler(tick, tick2, done, influxDBHost, influxDBPort, influxDBToken, influxDBOrg, influxDBBucket, geoUser, geoPass, env.Config.GeoSystemID, env.Config.CalorificValue, env.DebugMode, env.Logger)
-				sigs := make(chan os.Signal, 1)
-				signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-				<-sigs
-				done <- true
-			}()
-		}
+	// Initialise metrics exporter
+	if enableMetrics {
+		env.Metrics = metrics.NewExporter()
+		enabledSinks = append(enabledSinks, sinks.Metrics{Exporter: env.Metrics, Schema: schema})
 	}
 
-	// Initialize API
-	if env.EnableAPI {
-		// Initialize router
-		r := mux.NewRouter().StrictSlash(true)
+	// Initialise live stream broker
+	if enableStream {
+		broker = stream.NewBroker()
+		env.LiveStream = broker
+		enabledSinks = append(enabledSinks, broker)
+	}
 
-		// Initialize routes
-		routes.Initialize(r, env)
+	// Initialise WebSocket hub
+	if enableWebSocket {
+		hub = stream.NewHub(logger)
+		env.WebSocket = hub
+		enabledSinks = append(enabledSinks, hub)
+	}
 
-		// Initialize http server
-		env.Logger.Println("Starting API server")
-		s := &http.Server{
-			Addr:         ":" + httpPort,    // configure the bind address
-			Handler:      r,                 // set the default handler
-			ErrorLog:     env.Logger,        // set the logger for the server
-			IdleTimeout:  120 * time.Second, // max time to read request from the client
-			ReadTimeout:  5 * time.Second,   // max time to write response to the client
-			WriteTimeout: 10 * time.Second,  // max time for connections using TCP Keep-Alive
-		}
-		// Run http server
-		go func() {
-			err := s.ListenAndServe()
-			if err != nil {
-				env.Logger.Printf("Error starting server: %s\n", err)
-				os.Exit(1)
-			}
-		}()
+	// Initialise MQTT publisher
+	if enableMQTT {
+		enabledSinks = append(enabledSinks, mqtt.New(mqttConfig, logger))
+	}
 
-		// Listen for interrupts
-		sigChan := make(chan os.Signal)
-		signal.Notify(sigChan, os.Interrupt)
-		signal.Notify(sigChan, os.Kill)
+	// Query history from the store if enabled, otherwise from InfluxDB
+	if localStore != nil {
+		env.History = localStore
+	} else if influxDB != nil {
+		env.History = influxDB
+	}
 
-		sig := <-sigChan
-		env.Logger.Println("Got signal:", sig)
+	// Initialise backfill runner
+	if influxDB != nil {
+		env.Backfill = backfill.NewRunner(systems, influxDB, schema, filepath.Join(filepath.Dir(configFile), "backfill.json"), logger)
+	}
 
-		tc, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		err = s.Shutdown(tc)
-		if err != nil {
-			env.Logger.Fatal(err)
-		} else {
-			env.Logger.Println("Shutdown Server")
-		}
+	return &app{
+		env:              env,
+		influxDB:         influxDB,
+		broker:           broker,
+		hub:              hub,
+		sinks:            enabledSinks,
+		configDir:        filepath.Dir(configFile),
+		httpPort:         httpPort,
+		unixSocket:       unixSocket,
+		unixSocketMode:   os.FileMode(socketMode),
+		tlsConfig:        tlsConfig,
+		liveInterval:     liveInterval,
+		periodicInterval: periodicInterval,
 	}
 }
 
@@ -248,11 +769,17 @@ func checkConfig(envKey, defaultValue, name, validationType string, logger *log.
 		if err != nil {
 			valid = false
 		}
+	case "octal":
+		_, err := strconv.ParseUint(checkVal, 8, 32)
+		if err != nil {
+			valid = false
+		}
 	case "url":
 		_, err := url.ParseRequestURI(checkVal)
 		if err != nil {
 			valid = false
 		}
+	case "optional":
 	case "":
 		if checkVal == "" {
 			valid = false
@@ -264,189 +791,167 @@ func checkConfig(envKey, defaultValue, name, validationType string, logger *log.
 	return checkVal
 }
 
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseNetworks parses a comma separated list of CIDRs and IP addresses, such as
+// "10.0.0.0/8,192.168.1.2", where an IP address is a network of just itself.
+func parseNetworks(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range splitList(s) {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", item)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			item = fmt.Sprintf("%s/%d", item, bits)
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// retryStartup calls fn until it succeeds or returns a permanent error, waiting between
+// attempts while the geo API is unavailable.
+func retryStartup(name string, logger *log.Logger, fn func() error) error {
+	for {
+		err := fn()
+		if !geoapi.IsTransient(err) {
+			return err
+		}
+		logger.Printf("Unable to %s, retrying in %s: %s\n", name, startupRetryDelay, err)
+		time.Sleep(startupRetryDelay)
+	}
+}
+
+// logFetchError logs an error from fetching meter data, noting whether it is expected to
+// clear on a later run.
+func logFetchError(name string, err error, logger *log.Logger) {
+	if geoapi.IsTransient(err) {
+		logger.Printf("Unable to get %s, will retry on next run: %s\n", name, err)
+	} else {
+		logger.Printf("Error getting %s: %s\n", name, err)
+	}
+}
+
 func outputJSON(data interface{}, msg string, debugMode bool, logger *log.Logger) {
 	dataParsed, err := json.MarshalIndent(data, "", "  ")
-	checkErr(err, debugMode, logger)
+	if err != nil {
+		logger.Println(err)
+		return
+	}
 	logger.Printf("%s: \n%s", msg, string(dataParsed))
 }
 
-func scheduler(tick *time.Ticker, tick2 *time.Ticker, done chan bool, influxDBHost, influxDBPort, influxDBToken, influxDBOrg, influxDBBucket, geoUser, geoPass, geoSystemID string, calorificValue float64, debugMode bool, logger *log.Logger) {
+func scheduler(tick *time.Ticker, tick2 *time.Ticker, done chan bool, dispatcher *sinks.Dispatcher, hub *stream.Hub, snapshots *snapshot.Cache, system models.System, calorificValue float64, debugMode bool, logger *log.Logger) {
 	// Run once when first started
-	getMeterData(time.Now(), influxDBHost, influxDBPort, influxDBToken, influxDBOrg, influxDBBucket, geoUser, geoPass, geoSystemID, calorificValue, true, true, debugMode, logger)
+	getMeterData(time.Now(), dispatcher, hub, snapshots, system, calorificValue, true, true, debugMode, logger)
 	for {
 		select {
 		case t := <-tick.C:
 			// Run live at interval
-			getMeterData(t, influxDBHost, influxDBPort, influxDBToken, influxDBOrg, influxDBBucket, geoUser, geoPass, geoSystemID, calorificValue, true, false, debugMode, logger)
+			getMeterData(t, dispatcher, hub, snapshots, system, calorificValue, true, false, debugMode, logger)
 		case t2 := <-tick2.C:
 			// Run periodic at interval
-			getMeterData(t2, influxDBHost, influxDBPort, influxDBToken, influxDBOrg, influxDBBucket, geoUser, geoPass, geoSystemID, calorificValue, false, true, debugMode, logger)
+			getMeterData(t2, dispatcher, hub, snapshots, system, calorificValue, false, true, debugMode, logger)
 		case <-done:
+			tick.Stop()
+			tick2.Stop()
 			return
 		}
 	}
 }
 
-func getMeterData(t time.Time, influxDBHost, influxDBPort, influxDBToken, influxDBOrg, influxDBBucket, geoUser, geoPass, geoSystemID string, calorificValue float64, runLive, runPeriodic, debugMode bool, logger *log.Logger) {
+func getMeterData(t time.Time, dispatcher *sinks.Dispatcher, hub *stream.Hub, snapshots *snapshot.Cache, system models.System, calorificValue float64, runLive, runPeriodic, debugMode bool, logger *log.Logger) {
+	// Skip run while the geo API circuit breaker is open
+	if system.Geo.Breaker.Open() {
+		if debugMode {
+			logger.Println("Skipping run while geo API circuit breaker is open at", t)
+		}
+		return
+	}
+
 	// Debug output
 	if debugMode {
 		if runLive {
-			logger.Println("Running get live data at", t)
+			logger.Printf("Running get live data for %s at %s\n", system.ID, t)
 		} else {
-			logger.Println("Running get periodic data at", t)
+			logger.Printf("Running get periodic data for %s at %s\n", system.ID, t)
 		}
 	}
 
-	// Get an access token
-	accessToken, err := geo.GetAccessToken(geoUser, geoPass)
-	checkErr(err, debugMode, logger)
-
-	var data []string
-
 	if runLive {
-		// Get live meter data
-		lData := getLiveMeterData(accessToken, geoSystemID, debugMode, logger)
-		if len(lData) > 0 {
-			// Debug output
-			if debugMode {
-				outputJSON(lData, "Writing records", debugMode, logger)
-			}
-			data = append(data, lData...)
+		// Get live meter data and write it to sinks
+		readings, err := getLiveMeterData(system, snapshots, debugMode, logger)
+		if err != nil {
+			logFetchError("live meter data for "+system.ID, err, logger)
+			hub.Alert(models.Alert{Type: "fetch_failed", System: system.ID, Message: "Unable to get live meter data: " + err.Error()})
+		} else {
+			dispatcher.Write(readings)
 		}
 	}
 
 	if runPeriodic {
-		// Get periodic meter data
-		pData := getPeriodicMeterData(accessToken, geoSystemID, calorificValue, debugMode, logger)
-		if len(pData) > 0 {
-			// Debug output
-			if debugMode {
-				outputJSON(pData, "Writing records", debugMode, logger)
-			}
-			data = append(data, pData...)
+		// Get periodic meter data and write it to sinks
+		readings, err := getPeriodicMeterData(system, snapshots, calorificValue, debugMode, logger)
+		if err != nil {
+			logFetchError("periodic meter data for "+system.ID, err, logger)
+			hub.Alert(models.Alert{Type: "fetch_failed", System: system.ID, Message: "Unable to get periodic meter data: " + err.Error()})
+		} else {
+			dispatcher.Write(readings)
 		}
 	}
-
-	// Write data to InfluxDB if exists
-	if len(data) > 0 {
-		// Init InfluxDB client
-		client := influxDBClient(influxDBHost, influxDBPort, influxDBToken)
-		// Write records
-		influxDBWriteRecords(data, client, influxDBOrg, influxDBBucket, logger)
-	}
 }
 
-func getPeriodicMeterData(accessToken, geoSystemID string, calorificValue float64, debugMode bool, logger *log.Logger) []string {
-
+func getPeriodicMeterData(system models.System, snapshots *snapshot.Cache, calorificValue float64, debugMode bool, logger *log.Logger) (models.Readings, error) {
 	// Get periodic meter data
-	periodicData, err := geo.GetPeriodicMeterData(accessToken, geoSystemID)
-	checkErr(err, debugMode, logger)
+	periodicData, err := system.Geo.GetPeriodicMeterData(system.ID)
+	if err != nil {
+		return models.Readings{}, err
+	}
 	// Debug output
 	if debugMode {
 		outputJSON(periodicData, "Periodic meter data", debugMode, logger)
 	}
 
-	var pData []string
+	// Update snapshot for the API
+	fetchedAt := time.Now()
+	snapshots.Set(snapshot.PeriodicKey(system.ID), periodicData, fetchedAt)
 
-	// Add consumption readings
-	if periodicData.TotalConsumptionTimestamp > 0 && len(periodicData.TotalConsumptionList) > 0 {
-		for _, item := range periodicData.TotalConsumptionList {
-			if item.ValueAvailable {
-				totalConsumption := item.TotalConsumption
-				if item.CommodityType == "GAS_ENERGY" {
-					pData = append(pData, fmt.Sprintf("meterdata,source=periodic,unit=m3,type=%s val=%f %d", item.CommodityType, item.TotalConsumption, item.ReadingTime))
-					totalConsumption = geo.ConvertToKWH(item.TotalConsumption, calorificValue)
-				}
-				pData = append(pData, fmt.Sprintf("meterdata,source=periodic,unit=watts,type=%s val=%f %d", item.CommodityType, totalConsumption, item.ReadingTime))
-			}
-		}
-	}
-
-	// Add bill to date
-	if periodicData.BillToDateTimestamp > 0 && len(periodicData.BillToDateList) > 0 {
-		for _, item := range periodicData.BillToDateList {
-			pData = append(pData, fmt.Sprintf("meterdata_bill,source=periodic,type=%s,validutc=%d,startutc=%d val=%f %d", item.CommodityType, item.ValidUTC, item.StartUTC, item.BillToDate, periodicData.BillToDateTimestamp))
-		}
-	}
-
-	// Add active tariff data
-	if periodicData.ActiveTariffTimestamp > 0 && len(periodicData.ActiveTariffList) > 0 {
-		for _, item := range periodicData.ActiveTariffList {
-			if item.ValueAvailable {
-				pData = append(pData, fmt.Sprintf("meterdata_tariff,source=periodic,type=%s val=%f %d", item.CommodityType, item.ActiveTariffPrice, periodicData.ActiveTariffTimestamp))
-			}
-		}
-	}
-
-	// Add current electricity costs
-	if periodicData.CurrentCostsElecTimestamp > 0 && len(periodicData.CurrentCostsElec) > 0 {
-		for _, item := range periodicData.CurrentCostsElec {
-			pData = append(pData, fmt.Sprintf("meterdata_currentcosts,source=periodic,type=%s,duration=%s,subtype=cost val=%f %d", item.CommodityType, item.Duration, item.CostAmount, periodicData.CurrentCostsElecTimestamp))
-			pData = append(pData, fmt.Sprintf("meterdata_currentcosts,source=periodic,type=%s,duration=%s,subtype=energy val=%f %d", item.CommodityType, item.Duration, item.EnergyAmount, periodicData.CurrentCostsElecTimestamp))
-		}
-	}
-
-	// Add current gas costs
-	if periodicData.CurrentCostsGasTimestamp > 0 && len(periodicData.CurrentCostsGas) > 0 {
-		for _, item := range periodicData.CurrentCostsGas {
-			pData = append(pData, fmt.Sprintf("meterdata_currentcosts,source=periodic,type=%s,duration=%s,subtype=cost val=%f %d", item.CommodityType, item.Duration, item.CostAmount, periodicData.CurrentCostsGasTimestamp))
-			pData = append(pData, fmt.Sprintf("meterdata_currentcosts,source=periodic,type=%s,duration=%s,subtype=energy val=%f %d", item.CommodityType, item.Duration, item.EnergyAmount, periodicData.CurrentCostsGasTimestamp))
-		}
-	}
-
-	return pData
+	return models.PeriodicReadings(system, periodicData, calorificValue, fetchedAt), nil
 }
 
-func getLiveMeterData(accessToken, geoSystemID string, debugMode bool, logger *log.Logger) []string {
+func getLiveMeterData(system models.System, snapshots *snapshot.Cache, debugMode bool, logger *log.Logger) (models.Readings, error) {
 	// Get live meter data
-	liveData, err := geo.GetLiveMeterData(accessToken, geoSystemID)
-	checkErr(err, debugMode, logger)
+	liveData, err := system.Geo.GetLiveMeterData(system.ID)
+	if err != nil {
+		return models.Readings{}, err
+	}
 	// Debug output
 	if debugMode {
 		outputJSON(liveData, "Live meter data", debugMode, logger)
 	}
 
-	var lData []string
-
-	// Add power readings
-	if liveData.PowerTimestamp > 0 && len(liveData.Power) > 0 {
-		for _, item := range liveData.Power {
-			if item.ValueAvailable {
-				lData = append(lData, fmt.Sprintf("meterdata,source=live,unit=watts,type=%s val=%f %d", item.Type, item.Watts, liveData.PowerTimestamp))
-			}
-		}
-	}
-
-	return lData
-}
-
-func influxDBClient(influxDBHost, influxDBPort, influxDBToken string) influxdb2.Client {
-	// Init InfluxDB client and set the timestamp precision
-	c := influxdb2.NewClientWithOptions(influxDBHost+":"+influxDBPort, influxDBToken, influxdb2.DefaultOptions().SetPrecision(time.Second))
-	// Always close client at the end
-	defer c.Close()
-	// Return InfluxDB client
-	return c
-}
-
-func influxDBWriteRecords(recordsList []string, client influxdb2.Client, influxDBOrg, influxDBBucket string, logger *log.Logger) {
-	// get non-blocking write client
-	writeAPI := client.WriteAPI(influxDBOrg, influxDBBucket)
-
-	// Get errors channel
-	errorsCh := writeAPI.Errors()
-	// Create go proc for reading and logging errors
-	go func() {
-		for err := range errorsCh {
-			logger.Printf("InfluxDB write error: %s\n", err.Error())
-		}
-	}()
-
-	// write records in line protocol
-	for _, record := range recordsList {
-		writeAPI.WriteRecord(record)
-	}
+	// Update snapshot for the API
+	fetchedAt := time.Now()
+	snapshots.Set(snapshot.LiveKey(system.ID), liveData, fetchedAt)
 
-	// Flush writes
-	writeAPI.Flush()
+	return models.LiveReadings(system, liveData, fetchedAt), nil
 }
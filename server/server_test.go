@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"github.com/olivercullimore/geo-energy-data/server/controllers"
+	"github.com/olivercullimore/geo-energy-data/server/middleware"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"github.com/olivercullimore/geo-energy-data/server/stream"
+	"github.com/olivercullimore/geo-energy-data/server/tlsreload"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its key to dir, returning the
+// certificate too.
+func writeTestCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, certFile, keyFile
+}
+
+// TestStreamPastWriteTimeout keeps live streams open over TLS for longer than the server's write
+// timeout, checking events are still sent over both HTTP/1.1 and HTTP/2.
+func TestStreamPastWriteTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits longer than the write timeout")
+	}
+	logger := log.New(ioutil.Discard, "", 0)
+	cert, certFile, keyFile := writeTestCert(t, t.TempDir())
+	reloader, err := tlsreload.New(certFile, keyFile, "", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	for _, protoMajor := range []int{1, 2} {
+		protoMajor := protoMajor
+		t.Run(fmt.Sprintf("HTTP/%d", protoMajor), func(t *testing.T) {
+			t.Parallel()
+
+			// Serve the live stream as the API does
+			broker := stream.NewBroker()
+			defer broker.Close()
+			env := &models.Env{Logger: logger, LiveStream: broker}
+			s := newServer(&middleware.AppHandler{Env: env, Handler: controllers.APIStreamLive}, reloader.Config(tls.NoClientCert), logger)
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go s.ServeTLS(listener, "", "")
+			defer s.Close()
+
+			// Open the stream
+			transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+			if protoMajor == 2 {
+				transport.ForceAttemptHTTP2 = true
+			} else {
+				transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+			}
+			client := &http.Client{Transport: transport}
+			defer client.CloseIdleConnections()
+			resp, err := client.Get("https://" + listener.Addr().String() + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.ProtoMajor != protoMajor {
+				t.Fatalf("protocol = %s, want HTTP/%d", resp.Proto, protoMajor)
+			}
+			events := make(chan string)
+			go func() {
+				defer close(events)
+				scanner := bufio.NewScanner(resp.Body)
+				for scanner.Scan() {
+					if strings.HasPrefix(scanner.Text(), "event: ") {
+						events <- scanner.Text()
+					}
+				}
+			}()
+
+			// Send an event after the write timeout has passed
+			time.Sleep(s.WriteTimeout + 2*time.Second)
+			err = broker.Write(models.Readings{
+				System:    "test",
+				Source:    models.SourceLive,
+				FetchedAt: time.Now(),
+				Power:     []models.PowerReading{{Commodity: "ELECTRICITY", Watts: 500}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case event, ok := <-events:
+				if !ok {
+					t.Fatal("stream closed before the event was sent")
+				}
+				if event != "event: live" {
+					t.Errorf("event = %q, want live", event)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("event not received")
+			}
+		})
+	}
+}
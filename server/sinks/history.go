@@ -0,0 +1,108 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"sort"
+	"time"
+)
+
+const (
+	// historyQueryTimeout is the longest a history query to InfluxDB is allowed to take
+	historyQueryTimeout = 30 * time.Second
+)
+
+// Power returns live power for each commodity, aggregated over each step by InfluxDB.
+func (s *InfluxDB) Power(query models.HistoryQuery) ([]models.HistorySeries, error) {
+	fn := models.AggregateMean
+	if query.Aggregate == models.AggregateMax || query.Aggregate == models.AggregateMin {
+		fn = query.Aggregate
+	}
+	flux := fmt.Sprintf(`from(bucket: %q)
+  |> range(start: %s, stop: %s)
+  |> filter(fn: (r) => r._measurement == %q and r._field == "val" and r.source == %q and r.unit == %q and %s)
+  |> group(columns: ["type"])
+  |> aggregateWindow(every: %ds, fn: %s, createEmpty: false, timeSrc: "_start")`,
+		s.bucket, fluxTime(query.From), fluxTime(query.To), models.MeasurementMeterData, models.SourceLive, s.schema.PowerUnit(), systemFilter(query),
+		int64(query.Step/time.Second), fn)
+	return s.querySeries(flux, "W", time.Time{})
+}
+
+// Energy returns the energy used by each commodity in each interval, worked out by InfluxDB from the
+// change in the total consumption meter readings since the previous interval with readings.
+func (s *InfluxDB) Energy(query models.HistoryQuery) ([]models.HistorySeries, error) {
+	first := models.IntervalStart(query.From, query.Interval)
+	every := "1d"
+	offset := "0s"
+	switch query.Interval {
+	case models.IntervalHour:
+		every = "1h"
+	case models.IntervalWeek:
+		// Windows are aligned to the Unix epoch, a Thursday, so shift them to start on Monday
+		every = "1w"
+		offset = "4d"
+	case models.IntervalMonth:
+		every = "1mo"
+	}
+	flux := fmt.Sprintf(`from(bucket: %q)
+  |> range(start: %s, stop: %s)
+  |> filter(fn: (r) => r._measurement == %q and r._field == "val" and r.source == %q and r.unit == %q and %s)
+  |> group(columns: ["type"])
+  |> aggregateWindow(every: %s, offset: %s, fn: last, createEmpty: false, timeSrc: "_start")
+  |> difference(nonNegative: true)`,
+		s.bucket, fluxTime(models.PreviousInterval(first, query.Interval)), fluxTime(query.To), models.MeasurementMeterData, models.SourcePeriodic, s.schema.EnergyUnit(), systemFilter(query),
+		every, offset)
+	return s.querySeries(flux, "kWh", first)
+}
+
+// querySeries runs a query returning a table for each commodity, skipping values before from.
+func (s *InfluxDB) querySeries(flux, unit string, from time.Time) ([]models.HistorySeries, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), historyQueryTimeout)
+	defer cancel()
+	result, err := s.client.QueryAPI(s.org).Query(ctx, flux)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	byCommodity := map[string]*models.HistorySeries{}
+	for result.Next() {
+		record := result.Record()
+		value, ok := record.Value().(float64)
+		if !ok || record.Time().Before(from) {
+			continue
+		}
+		commodity, _ := record.ValueByKey("type").(string)
+		series, ok := byCommodity[commodity]
+		if !ok {
+			series = &models.HistorySeries{Commodity: commodity, Unit: unit, Points: []models.HistoryPoint{}}
+			byCommodity[commodity] = series
+		}
+		series.Points = append(series.Points, models.HistoryPoint{Time: record.Time().UTC(), Value: value})
+	}
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	series := make([]models.HistorySeries, 0, len(byCommodity))
+	for _, s := range byCommodity {
+		series = append(series, *s)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].Commodity < series[j].Commodity
+	})
+	return series, nil
+}
+
+// systemFilter returns a Flux predicate matching the points of the queried system.
+func systemFilter(query models.HistoryQuery) string {
+	if query.Untagged {
+		return fmt.Sprintf(`(not exists r.system or r.system == %q)`, query.SystemID)
+	}
+	return fmt.Sprintf(`r.system == %q`, query.SystemID)
+}
+
+func fluxTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
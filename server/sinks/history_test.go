@@ -0,0 +1,93 @@
+package sinks
+
+import (
+	"encoding/json"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// historyResult is an InfluxDB query result with a table of values for each commodity.
+const historyResult = `#datatype,string,long,dateTime:RFC3339,double,string
+#group,false,false,false,false,true
+#default,_result,,,,
+,result,table,_time,_value,type
+,,0,2021-02-01T00:00:00Z,1.5,ELECTRICITY
+,,0,2021-02-01T01:00:00Z,2.5,ELECTRICITY
+,,1,2021-02-01T01:00:00Z,4,GAS_ENERGY
+
+`
+
+// newTestHistory returns an InfluxDB sink querying a server that responds with historyResult,
+// and the last Flux query it received.
+func newTestHistory(t *testing.T, schema models.Schema) (*InfluxDB, func() string) {
+	t.Helper()
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Query string `json:"query"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			t.Error(err)
+		}
+		query = body.Query
+		w.Header().Set("Content-Type", "text/csv")
+		_, _ = w.Write([]byte(historyResult))
+	}))
+	t.Cleanup(server.Close)
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewInfluxDB("http://"+serverURL.Hostname(), serverURL.Port(), "token", "org", "bucket", schema, nil, log.New(ioutil.Discard, "", 0))
+	t.Cleanup(sink.Close)
+	return sink, func() string {
+		return query
+	}
+}
+
+func TestInfluxDBHistory(t *testing.T) {
+	from := time.Date(2021, 2, 1, 1, 0, 0, 0, time.UTC)
+	for _, schema := range []models.Schema{{Version: models.SchemaV1}, {Version: models.SchemaV2}} {
+		schema := schema
+		t.Run(schema.EnergyUnit(), func(t *testing.T) {
+			sink, query := newTestHistory(t, schema)
+
+			// Power is filtered on the unit of the schema
+			series, err := sink.Power(models.HistoryQuery{SystemID: "system-1", From: from, To: from.Add(time.Hour), Step: time.Minute, Aggregate: models.AggregateMax})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q := query(); !strings.Contains(q, `r.unit == "`+schema.PowerUnit()+`"`) || !strings.Contains(q, "fn: max") {
+				t.Errorf("power query doesn't filter on %s with max:\n%s", schema.PowerUnit(), q)
+			}
+			if len(series) != 2 || series[0].Unit != "W" || len(series[0].Points) != 2 {
+				t.Errorf("power = %+v", series)
+			}
+
+			// Energy is filtered on the unit of the schema, skipping values before the first interval
+			series, err = sink.Energy(models.HistoryQuery{SystemID: "system-1", Untagged: true, From: from, To: from.Add(2 * time.Hour), Interval: models.IntervalHour})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q := query(); !strings.Contains(q, `r.unit == "`+schema.EnergyUnit()+`"`) || !strings.Contains(q, `not exists r.system`) {
+				t.Errorf("energy query doesn't filter on %s including untagged points:\n%s", schema.EnergyUnit(), q)
+			}
+			want := []models.HistorySeries{
+				{Commodity: "ELECTRICITY", Unit: "kWh", Points: []models.HistoryPoint{{Time: from, Value: 2.5}}},
+				{Commodity: "GAS_ENERGY", Unit: "kWh", Points: []models.HistoryPoint{{Time: from, Value: 4}}},
+			}
+			if !reflect.DeepEqual(series, want) {
+				t.Errorf("energy = %+v, want %+v", series, want)
+			}
+		})
+	}
+}
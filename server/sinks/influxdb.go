@@ -0,0 +1,130 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"github.com/olivercullimore/geo-energy-data/server/spool"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// influxDBWriteTimeout is the longest a write to InfluxDB is allowed to take
+	influxDBWriteTimeout = 30 * time.Second
+)
+
+// InfluxDB writes readings to InfluxDB through a single long-lived client, spooling any that fail
+// to write so they can be replayed later.
+type InfluxDB struct {
+	client influxdb2.Client
+	org    string
+	bucket string
+	schema models.Schema
+	spool  *spool.Spool
+	logger *log.Logger
+}
+
+// NewInfluxDB returns an InfluxDB sink writing points with schema, using writeSpool for records
+// that fail to write.
+func NewInfluxDB(influxDBHost, influxDBPort, influxDBToken, influxDBOrg, influxDBBucket string, schema models.Schema, writeSpool *spool.Spool, logger *log.Logger) *InfluxDB {
+	// Init InfluxDB client and set the timestamp precision
+	client := influxdb2.NewClientWithOptions(influxDBHost+":"+influxDBPort, influxDBToken, influxdb2.DefaultOptions().SetPrecision(time.Second))
+	return &InfluxDB{
+		client: client,
+		org:    influxDBOrg,
+		bucket: influxDBBucket,
+		schema: schema,
+		spool:  writeSpool,
+		logger: logger,
+	}
+}
+
+// Name returns the name of the sink.
+func (s *InfluxDB) Name() string {
+	return "InfluxDB"
+}
+
+// Write writes readings to InfluxDB after replaying any spooled records, so writes stay in order.
+// If the readings can't be written they are added to the spool and the write error is returned,
+// unless InfluxDB rejected them, when they are moved aside instead.
+func (s *InfluxDB) Write(readings models.Readings) error {
+	records := Records(readings, s.schema)
+	if len(records) == 0 {
+		return nil
+	}
+
+	// Replay any spooled records first
+	written, err := s.spool.Replay(s.writeRecords)
+	if written > 0 {
+		s.logger.Printf("Wrote %d spooled batches to InfluxDB\n", written)
+	}
+
+	// Write records
+	if err == nil {
+		err = s.writeRecords(records)
+		if err == nil {
+			return nil
+		}
+	}
+
+	// Move records aside if they were rejected, as writing them again would never succeed
+	if spool.IsRejected(err) {
+		spoolErr := s.spool.Reject(records)
+		if spoolErr != nil {
+			s.logger.Printf("Unable to move rejected records aside: %s\n", spoolErr)
+		}
+		return err
+	}
+
+	// Spool records as they couldn't be written
+	spoolErr := s.spool.Add(records)
+	if spoolErr != nil {
+		s.logger.Printf("Unable to spool records: %s\n", spoolErr)
+		return err
+	}
+	return fmt.Errorf("spooled %d records: %s", len(records), err)
+}
+
+// Close closes the InfluxDB client.
+func (s *InfluxDB) Close() {
+	s.client.Close()
+}
+
+// Records converts readings to line protocol records written with schema.
+func Records(readings models.Readings, schema models.Schema) []string {
+	var records []string
+	for _, point := range readings.Points(schema) {
+		records = append(records, point.LineProtocol())
+	}
+	return records
+}
+
+// writeRecords writes records to InfluxDB, returning a spool.RejectedError if InfluxDB rejects
+// them with a client error such as invalid line protocol or a field type conflict. Client errors
+// caused by the configuration rather than the records, such as an invalid token or missing bucket,
+// aren't rejections so the records are kept until it's fixed.
+func (s *InfluxDB) writeRecords(records []string) error {
+	// Get a blocking write client. A new one is used for each write as the client keeps failed
+	// writes queued internally, whereas the spool is relied on for retrying them.
+	writeAPI := api.NewWriteAPIBlocking(s.org, s.bucket, s.client.HTTPService(), s.client.Options().WriteOptions())
+
+	// Write records in line protocol
+	ctx, cancel := context.WithTimeout(context.Background(), influxDBWriteTimeout)
+	defer cancel()
+	err := writeAPI.WriteRecord(ctx, records...)
+	var httpErr *ihttp.Error
+	if errors.As(err, &httpErr) && httpErr.StatusCode >= 400 && httpErr.StatusCode < 500 {
+		switch httpErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		default:
+			return &spool.RejectedError{Err: err}
+		}
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package sinks
+
+import (
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"github.com/olivercullimore/geo-energy-data/server/spool"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestInfluxDBRejectedWrites(t *testing.T) {
+	// Respond to each write with the next status
+	var mu sync.Mutex
+	statuses := []int{http.StatusServiceUnavailable, http.StatusBadRequest, http.StatusNoContent}
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+		status := http.StatusNoContent
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		if status >= 400 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"code":"invalid","message":"failure"}`))
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.New(ioutil.Discard, "", 0)
+	writeSpool, err := spool.New(t.TempDir(), 0, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewInfluxDB("http://"+serverURL.Hostname(), serverURL.Port(), "token", "org", "bucket", models.Schema{Version: models.SchemaV2}, writeSpool, logger)
+	defer sink.Close()
+	readings := func(watts float64) models.Readings {
+		return models.Readings{
+			System: "system-1",
+			Source: models.SourceLive,
+			Power:  []models.PowerReading{{Commodity: "ELECTRICITY", Watts: watts, Timestamp: 1612345678}},
+		}
+	}
+
+	// Records that fail to write for now are spooled
+	err = sink.Write(readings(100))
+	if err == nil || writeSpool.Len() != 1 {
+		t.Fatalf("write = %v with %d spooled batches, want an error and 1", err, writeSpool.Len())
+	}
+
+	// A spooled batch InfluxDB rejects is moved aside, so the next readings are still written
+	err = sink.Write(readings(200))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if writeSpool.Len() != 0 {
+		t.Errorf("%d spooled batches, want 0", writeSpool.Len())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 3 || !strings.Contains(bodies[1], "val=100") || !strings.Contains(bodies[2], "val=200") {
+		t.Errorf("writes = %q, want the spooled batch then the new readings", bodies)
+	}
+}
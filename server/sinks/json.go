@@ -0,0 +1,55 @@
+package sinks
+
+import (
+	"encoding/json"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"io"
+	"os"
+	"sync"
+)
+
+// JSON writes readings as JSON lines, one set of readings per line, to stdout or a file.
+type JSON struct {
+	name string
+	mu   sync.Mutex
+	out  io.Writer
+	file *os.File
+}
+
+// NewStdout returns a sink writing readings to stdout.
+func NewStdout() *JSON {
+	return &JSON{name: "stdout", out: os.Stdout}
+}
+
+// NewFile returns a sink appending readings to the file at path, creating it if needed.
+func NewFile(path string) (*JSON, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSON{name: "file", out: file, file: file}, nil
+}
+
+// Name returns the name of the sink.
+func (s *JSON) Name() string {
+	return s.name
+}
+
+// Write writes readings as a single line of JSON.
+func (s *JSON) Write(readings models.Readings) error {
+	line, err := json.Marshal(readings)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.out.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file, if writing to one.
+func (s *JSON) Close() {
+	if s.file != nil {
+		_ = s.file.Close()
+	}
+}
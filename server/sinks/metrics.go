@@ -0,0 +1,61 @@
+package sinks
+
+import (
+	"github.com/olivercullimore/geo-energy-data/server/metrics"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"time"
+)
+
+// Metrics updates a metrics exporter with the latest readings.
+type Metrics struct {
+	Exporter *metrics.Exporter
+	Schema   models.Schema
+}
+
+// Name returns the name of the sink.
+func (s Metrics) Name() string {
+	return "metrics"
+}
+
+// Write replaces the exporter's samples for the source of the readings.
+func (s Metrics) Write(readings models.Readings) error {
+	var samples []metrics.Sample
+	for _, r := range readings.Power {
+		samples = append(samples, metrics.Sample{Name: "geo_live_power_watts", Labels: map[string]string{"type": r.Commodity, "unit": s.Schema.PowerUnit()}, Value: r.Watts})
+	}
+	for _, r := range readings.Consumption {
+		unit := r.Unit
+		if unit == "kWh" {
+			unit = s.Schema.EnergyUnit()
+		}
+		samples = append(samples, metrics.Sample{Name: "geo_total_consumption", Labels: map[string]string{"type": r.Commodity, "unit": unit}, Value: r.Value})
+	}
+	for _, r := range readings.Bills {
+		// Label bills with their billing period, as there can be more than one for a commodity
+		labels := map[string]string{
+			"type":         r.Commodity,
+			"period_start": time.Unix(r.StartUTC, 0).UTC().Format(time.RFC3339),
+			"period_end":   time.Unix(r.ValidUTC, 0).UTC().Format(time.RFC3339),
+		}
+		samples = append(samples, metrics.Sample{Name: "geo_bill_to_date", Labels: labels, Value: r.Amount})
+	}
+	for _, r := range readings.Tariffs {
+		samples = append(samples, metrics.Sample{Name: "geo_active_tariff_price", Labels: map[string]string{"type": r.Commodity}, Value: r.Price})
+	}
+	for _, r := range readings.Costs {
+		samples = append(samples, metrics.Sample{Name: "geo_current_cost", Labels: map[string]string{"type": r.Commodity, "duration": r.Duration}, Value: r.Cost})
+		samples = append(samples, metrics.Sample{Name: "geo_current_energy", Labels: map[string]string{"type": r.Commodity, "duration": r.Duration}, Value: r.Energy})
+	}
+	// Label samples with the system they are for
+	for _, sample := range samples {
+		sample.Labels["system"] = readings.System
+		if readings.SystemName != "" {
+			sample.Labels["system_name"] = readings.SystemName
+		}
+	}
+	s.Exporter.Update(readings.System, readings.Source, samples)
+	return nil
+}
+
+// Close does nothing as the exporter keeps serving the last readings.
+func (s Metrics) Close() {}
@@ -0,0 +1,104 @@
+package sinks
+
+import (
+	"github.com/olivercullimore/geo-energy-data/server/metrics"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"io/ioutil"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	exporter := metrics.NewExporter()
+	sink := Metrics{Exporter: exporter, Schema: models.Schema{Version: models.SchemaV2}}
+	readings := models.Readings{
+		System:     "system-1",
+		SystemName: `Main "house"`,
+		Source:     models.SourcePeriodic,
+		Consumption: []models.ConsumptionReading{
+			{Commodity: "ELECTRICITY", Value: 1234.5, Unit: "kWh", Timestamp: 1612345678},
+			{Commodity: "GAS_ENERGY", Value: 567.8, Unit: "m3", Timestamp: 1612345678},
+		},
+		Bills: []models.BillReading{
+			{Commodity: "ELECTRICITY", Amount: 12.34, StartUTC: 1609459200, ValidUTC: 1612137599, Timestamp: 1612345678},
+			{Commodity: "ELECTRICITY", Amount: 5.67, StartUTC: 1612137600, ValidUTC: 1614556799, Timestamp: 1612345678},
+		},
+		Tariffs: []models.TariffReading{
+			{Commodity: "ELECTRICITY", Price: 0.1523, Timestamp: 1612345678},
+		},
+		Costs: []models.CostReading{
+			{Commodity: "ELECTRICITY", Duration: "DAY", Cost: 1.2, Energy: 8.5, Timestamp: 1612345678},
+			{Commodity: "ELECTRICITY", Duration: "WEEK", Cost: 7.5, Energy: 50.25, Timestamp: 1612345678},
+		},
+	}
+	err := sink.Write(readings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Write(models.Readings{System: "system-1", Source: models.SourceLive, Power: []models.PowerReading{{Commodity: "ELECTRICITY", Watts: 350, Timestamp: 1612345678}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	exporter.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(w.Result().Body)
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// Drop the last update timestamps, which depend on when the test runs
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+		if !strings.Contains(line, "geo_last_update_timestamp_seconds") {
+			lines = append(lines, line)
+		}
+	}
+	want := []string{
+		`# HELP geo_active_tariff_price Active tariff price.`,
+		`# TYPE geo_active_tariff_price gauge`,
+		`geo_active_tariff_price{system="system-1",system_name="Main \"house\"",type="ELECTRICITY"} 0.1523`,
+		`# HELP geo_bill_to_date Bill to date.`,
+		`# TYPE geo_bill_to_date gauge`,
+		`geo_bill_to_date{period_end="2021-01-31T23:59:59Z",period_start="2021-01-01T00:00:00Z",system="system-1",system_name="Main \"house\"",type="ELECTRICITY"} 12.34`,
+		`geo_bill_to_date{period_end="2021-02-28T23:59:59Z",period_start="2021-02-01T00:00:00Z",system="system-1",system_name="Main \"house\"",type="ELECTRICITY"} 5.67`,
+		`# HELP geo_current_cost Cost of energy used in the current period.`,
+		`# TYPE geo_current_cost gauge`,
+		`geo_current_cost{duration="DAY",system="system-1",system_name="Main \"house\"",type="ELECTRICITY"} 1.2`,
+		`geo_current_cost{duration="WEEK",system="system-1",system_name="Main \"house\"",type="ELECTRICITY"} 7.5`,
+		`# HELP geo_current_energy Energy used in the current period.`,
+		`# TYPE geo_current_energy gauge`,
+		`geo_current_energy{duration="DAY",system="system-1",system_name="Main \"house\"",type="ELECTRICITY"} 8.5`,
+		`geo_current_energy{duration="WEEK",system="system-1",system_name="Main \"house\"",type="ELECTRICITY"} 50.25`,
+		`# HELP geo_live_power_watts Live power usage in watts.`,
+		`# TYPE geo_live_power_watts gauge`,
+		`geo_live_power_watts{system="system-1",type="ELECTRICITY",unit="W"} 350`,
+		`# HELP geo_total_consumption Total consumption meter reading.`,
+		`# TYPE geo_total_consumption gauge`,
+		`geo_total_consumption{system="system-1",system_name="Main \"house\"",type="ELECTRICITY",unit="kWh"} 1234.5`,
+		`geo_total_consumption{system="system-1",system_name="Main \"house\"",type="GAS_ENERGY",unit="m3"} 567.8`,
+	}
+	if got := strings.Join(lines, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("exposition =\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+
+	// Prometheus rejects an exposition with the same series more than once
+	series := regexp.MustCompile(`^([a-z_]+(\{.*\})?) \S+$`)
+	seen := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := series.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("invalid sample line %q", line)
+			continue
+		}
+		if seen[m[1]] {
+			t.Errorf("duplicate series %s", m[1])
+		}
+		seen[m[1]] = true
+	}
+}
@@ -0,0 +1,141 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// migrateMeasurements are the measurements rewritten when migrating to a new schema
+var migrateMeasurements = []string{
+	models.MeasurementMeterData,
+	models.MeasurementBill,
+	models.MeasurementTariff,
+	models.MeasurementCurrentCosts,
+}
+
+// Migrate rewrites the points written between from and to, one day at a time, so their tags match
+// the sink's schema. Each day's points are read, saved to backupFile, deleted and written again
+// with the new tags. If a run stops part way through a day, the next run writes the points saved
+// in backupFile before carrying on. It returns the number of points rewritten.
+func (s *InfluxDB) Migrate(from, to time.Time, backupFile string) (int, error) {
+	// Restore points from an interrupted run
+	content, err := ioutil.ReadFile(backupFile)
+	if err == nil {
+		records := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+		s.logger.Printf("Writing %d points saved by an interrupted migration\n", len(records))
+		err = s.writeRecords(records)
+		if err != nil {
+			return 0, err
+		}
+		err = os.Remove(backupFile)
+		if err != nil {
+			return 0, err
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	migrated := 0
+	for day := from; !day.After(to); day = day.Add(24 * time.Hour) {
+		n, err := s.migrateDay(day, day.Add(24*time.Hour), backupFile)
+		if err != nil {
+			return migrated, fmt.Errorf("migrating %s: %w", day.Format("2006-01-02"), err)
+		}
+		migrated += n
+		if n > 0 {
+			s.logger.Printf("Migrated %d points for %s\n", n, day.Format("2006-01-02"))
+		}
+	}
+	return migrated, nil
+}
+
+// migrateDay rewrites the points between start and stop.
+func (s *InfluxDB) migrateDay(start, stop time.Time, backupFile string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), influxDBWriteTimeout)
+	defer cancel()
+
+	// Read points
+	points, err := s.queryPoints(ctx, start, stop)
+	if err != nil || len(points) == 0 {
+		return 0, err
+	}
+
+	// Convert points to the new schema and save them in case the rewrite is interrupted
+	records := make([]string, 0, len(points))
+	for _, point := range points {
+		records = append(records, s.schema.Upgrade(point).LineProtocol())
+	}
+	err = ioutil.WriteFile(backupFile, []byte(strings.Join(records, "\n")+"\n"), 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	// Delete the old points, as changing their tags makes them new series
+	deleteAPI := s.client.DeleteAPI()
+	for _, measurement := range migrateMeasurements {
+		err = deleteAPI.DeleteWithName(ctx, s.org, s.bucket, start, stop.Add(-time.Nanosecond), fmt.Sprintf("_measurement=%q", measurement))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Write the new points
+	err = s.writeRecords(records)
+	if err != nil {
+		return 0, err
+	}
+	return len(records), os.Remove(backupFile)
+}
+
+// queryPoints returns the points of the migrated measurements between start and stop.
+func (s *InfluxDB) queryPoints(ctx context.Context, start, stop time.Time) ([]models.Point, error) {
+	filters := make([]string, 0, len(migrateMeasurements))
+	for _, measurement := range migrateMeasurements {
+		filters = append(filters, fmt.Sprintf("r._measurement == %q", measurement))
+	}
+	query := fmt.Sprintf(`from(bucket: %q) |> range(start: %s, stop: %s) |> filter(fn: (r) => %s)`,
+		s.bucket, start.UTC().Format(time.RFC3339), stop.UTC().Format(time.RFC3339), strings.Join(filters, " or "))
+
+	result, err := s.client.QueryAPI(s.org).Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	var points []models.Point
+	for result.Next() {
+		record := result.Record()
+		var value float64
+		switch v := record.Value().(type) {
+		case float64:
+			value = v
+		case int64:
+			value = float64(v)
+		default:
+			continue
+		}
+
+		// Everything but the columns added by Flux is a tag
+		tags := map[string]string{}
+		for key, v := range record.Values() {
+			if strings.HasPrefix(key, "_") || key == "result" || key == "table" {
+				continue
+			}
+			if tag, ok := v.(string); ok {
+				tags[key] = tag
+			}
+		}
+		points = append(points, models.Point{
+			Measurement: record.Measurement(),
+			Tags:        tags,
+			Fields:      map[string]float64{record.Field(): value},
+			Timestamp:   record.Time().Unix(),
+		})
+	}
+	return points, result.Err()
+}
@@ -0,0 +1,96 @@
+package sinks
+
+import (
+	"fmt"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"log"
+	"sync"
+)
+
+const (
+	// queueSize is how many sets of readings can wait to be written to a sink before new ones are
+	// dropped
+	queueSize = 10
+)
+
+// Sink is a backend meter readings are written to.
+type Sink interface {
+	// Name identifies the sink in log messages.
+	Name() string
+	// Write writes a set of readings.
+	Write(readings models.Readings) error
+	// Close flushes and releases anything held by the sink.
+	Close()
+}
+
+// Dispatcher fans readings out to a set of sinks. Each sink is written to from its own goroutine
+// and queue, so a slow or failing sink does not hold up or affect the others.
+type Dispatcher struct {
+	workers   []*worker
+	debugMode bool
+	logger    *log.Logger
+	wg        sync.WaitGroup
+}
+
+type worker struct {
+	sink  Sink
+	queue chan models.Readings
+}
+
+// NewDispatcher returns a Dispatcher writing to sinks.
+func NewDispatcher(sinks []Sink, debugMode bool, logger *log.Logger) *Dispatcher {
+	d := &Dispatcher{debugMode: debugMode, logger: logger}
+	for _, sink := range sinks {
+		w := &worker{sink: sink, queue: make(chan models.Readings, queueSize)}
+		d.workers = append(d.workers, w)
+		d.wg.Add(1)
+		go d.run(w)
+	}
+	return d
+}
+
+// Write queues readings to be written to every sink. If a sink has fallen too far behind, the
+// readings are dropped for that sink only.
+func (d *Dispatcher) Write(readings models.Readings) {
+	if readings.Empty() {
+		return
+	}
+	for _, w := range d.workers {
+		select {
+		case w.queue <- readings:
+		default:
+			d.logger.Printf("%s sink is not keeping up, dropped %s readings\n", w.sink.Name(), readings.Source)
+		}
+	}
+}
+
+// Close writes any queued readings then closes every sink.
+func (d *Dispatcher) Close() {
+	for _, w := range d.workers {
+		close(w.queue)
+	}
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) run(w *worker) {
+	defer d.wg.Done()
+	for readings := range w.queue {
+		err := write(w.sink, readings)
+		if err != nil {
+			d.logger.Printf("%s sink write error: %s\n", w.sink.Name(), err)
+		} else if d.debugMode {
+			d.logger.Printf("Wrote %s readings to %s sink\n", readings.Source, w.sink.Name())
+		}
+	}
+	w.sink.Close()
+}
+
+// write writes readings to a sink, turning a panic into an error so it can't stop the worker.
+func write(sink Sink, readings models.Readings) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return sink.Write(readings)
+}
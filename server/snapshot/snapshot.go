@@ -0,0 +1,106 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Snapshot is the latest data fetched for a key.
+type Snapshot struct {
+	Data      interface{}
+	FetchedAt time.Time
+	// ETag is a strong entity tag computed from the data
+	ETag string
+}
+
+// Age returns how long ago the snapshot was fetched.
+func (s Snapshot) Age() time.Duration {
+	return time.Since(s.FetchedAt)
+}
+
+// entry holds the snapshot of a key, with a mutex so only one refresh of a key runs at a time.
+type entry struct {
+	refreshMu sync.Mutex
+	snapshot  Snapshot
+	ok        bool
+}
+
+// Cache keeps the latest data fetched from the geo API so it can be served without a request to
+// the geo API each time. It is safe for concurrent use.
+type Cache struct {
+	minRefresh time.Duration
+	mu         sync.Mutex
+	entries    map[string]*entry
+}
+
+// New returns an empty Cache which refreshes a key at most once every minRefresh.
+func New(minRefresh time.Duration) *Cache {
+	return &Cache{minRefresh: minRefresh, entries: map[string]*entry{}}
+}
+
+// LiveKey and PeriodicKey return the keys of the live and periodic meter data of a system.
+func LiveKey(systemID string) string {
+	return "live/" + systemID
+}
+
+func PeriodicKey(systemID string) string {
+	return "periodic/" + systemID
+}
+
+// Get returns the snapshot of a key, if there is one.
+func (c *Cache) Get(key string) (Snapshot, bool) {
+	e := c.entry(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return e.snapshot, e.ok
+}
+
+// Set replaces the snapshot of a key with data fetched at fetchedAt.
+func (c *Cache) Set(key string, data interface{}, fetchedAt time.Time) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256(body)
+	snapshot := Snapshot{Data: data, FetchedAt: fetchedAt, ETag: `"` + hex.EncodeToString(sum[:16]) + `"`}
+
+	e := c.entry(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.snapshot = snapshot
+	e.ok = true
+}
+
+// Refresh fetches and returns new data for a key, unless it was fetched less than the minimum
+// refresh interval ago, in which case the current snapshot is returned. Concurrent refreshes of a
+// key share a single fetch.
+func (c *Cache) Refresh(key string, fetch func() (interface{}, error)) (Snapshot, error) {
+	e := c.entry(key)
+	e.refreshMu.Lock()
+	defer e.refreshMu.Unlock()
+
+	if snapshot, ok := c.Get(key); ok && snapshot.Age() < c.minRefresh {
+		return snapshot, nil
+	}
+	data, err := fetch()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	c.Set(key, data, time.Now())
+	snapshot, _ := c.Get(key)
+	return snapshot, nil
+}
+
+func (c *Cache) entry(key string) *entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		e = &entry{}
+		c.entries[key] = e
+	}
+	return e
+}
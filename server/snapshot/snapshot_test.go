@@ -0,0 +1,122 @@
+package snapshot
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheRefreshSharesFetch(t *testing.T) {
+	c := New(time.Minute)
+	var fetches int32
+	release := make(chan struct{})
+	fetch := func() (interface{}, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return "data", nil
+	}
+
+	// Refreshes while a fetch is running wait for it rather than fetching again
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			snapshot, err := c.Refresh(LiveKey("system-1"), fetch)
+			if err != nil || snapshot.Data != "data" {
+				t.Errorf("refresh = %+v, %v", snapshot, err)
+			}
+		}()
+	}
+	for atomic.LoadInt32(&fetches) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("fetched %d times, want 1", n)
+	}
+
+	// Other keys are fetched separately
+	_, err := c.Refresh(PeriodicKey("system-1"), fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("fetched %d times, want 2", n)
+	}
+}
+
+func TestCacheMinRefresh(t *testing.T) {
+	const minRefresh = 50 * time.Millisecond
+	c := New(minRefresh)
+	var fetches int
+	fetch := func() (interface{}, error) {
+		fetches++
+		return fetches, nil
+	}
+
+	// A snapshot fetched less than minRefresh ago is returned instead of fetching
+	first, err := c.Refresh("key", fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.Refresh("key", fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 1 || second != first {
+		t.Errorf("fetched %d times with snapshot %+v, want 1 with %+v", fetches, second, first)
+	}
+
+	// Once it's older a new snapshot is fetched
+	time.Sleep(minRefresh)
+	third, err := c.Refresh("key", fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 2 || third.Data != 2 || third.ETag == first.ETag {
+		t.Errorf("fetched %d times with snapshot %+v, want 2 with new data", fetches, third)
+	}
+}
+
+func TestCacheRefreshFails(t *testing.T) {
+	c := New(time.Minute)
+	c.Set("key", "old", time.Now().Add(-time.Hour))
+	fetchErr := errors.New("Response Code: 503")
+	_, err := c.Refresh("key", func() (interface{}, error) {
+		return nil, fetchErr
+	})
+	if err != fetchErr {
+		t.Errorf("error = %v, want %v", err, fetchErr)
+	}
+
+	// A failed refresh keeps the current snapshot
+	snapshot, ok := c.Get("key")
+	if !ok || snapshot.Data != "old" {
+		t.Errorf("snapshot = %+v, %t, want the old snapshot", snapshot, ok)
+	}
+}
+
+func TestCacheETag(t *testing.T) {
+	c := New(0)
+	c.Set("a", map[string]int{"watts": 100}, time.Now())
+	c.Set("b", map[string]int{"watts": 100}, time.Now().Add(-time.Minute))
+	c.Set("c", map[string]int{"watts": 200}, time.Now())
+	a, _ := c.Get("a")
+	b, _ := c.Get("b")
+	other, _ := c.Get("c")
+
+	// Entity tags are strong and depend only on the data
+	if len(a.ETag) < 3 || a.ETag[0] != '"' || a.ETag[len(a.ETag)-1] != '"' {
+		t.Errorf("ETag %s isn't a strong entity tag", a.ETag)
+	}
+	if a.ETag != b.ETag {
+		t.Errorf("ETags %s and %s differ for the same data", a.ETag, b.ETag)
+	}
+	if a.ETag == other.ETag {
+		t.Errorf("ETag %s is the same for different data", a.ETag)
+	}
+}
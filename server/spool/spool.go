@@ -0,0 +1,240 @@
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// fileExt is the extension of spooled batch files
+	fileExt = ".lp"
+	// tmpExt is the extension of batch files that are still being written
+	tmpExt = ".tmp"
+	// rejectedDir is the subdirectory batches the database rejected are moved aside to
+	rejectedDir = "rejected"
+)
+
+// RejectedError is returned by a write when the database rejects a batch itself, such as for
+// invalid line protocol or a field type conflict, so writing it again would never succeed.
+type RejectedError struct {
+	Err error
+}
+
+func (e *RejectedError) Error() string {
+	return "batch rejected: " + e.Err.Error()
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// IsRejected reports whether an error was caused by the database rejecting a batch.
+func IsRejected(err error) bool {
+	var rejected *RejectedError
+	return errors.As(err, &rejected)
+}
+
+// Spool is a directory of batches of line protocol records that failed to write, kept on disk
+// so they can be replayed in order once the database is reachable again. It is safe for
+// concurrent use.
+type Spool struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	logger  *log.Logger
+	mu      sync.Mutex
+	seq     uint64
+}
+
+type batchFile struct {
+	name    string
+	size    int64
+	created time.Time
+}
+
+// New returns a Spool storing batches in dir, creating it if needed. Once the batches take up
+// more than maxSize bytes the oldest are dropped, as are any older than maxAge. Rejected batches
+// are moved aside to the rejected subdirectory, where they are kept for maxAge.
+func New(dir string, maxSize int64, maxAge time.Duration, logger *log.Logger) (*Spool, error) {
+	err := os.MkdirAll(filepath.Join(dir, rejectedDir), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Spool{dir: dir, maxSize: maxSize, maxAge: maxAge, logger: logger}, nil
+}
+
+// Add stores a batch of records at the end of the spool.
+func (s *Spool) Add(records []string) error {
+	if len(records) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.write(s.dir, records)
+	if err != nil {
+		return err
+	}
+
+	// Enforce size and age limits
+	return s.prune()
+}
+
+// Reject moves a batch of records the database rejected aside, so it can be inspected but isn't
+// replayed.
+func (s *Spool) Reject(records []string) error {
+	if len(records) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(filepath.Join(s.dir, rejectedDir), records)
+}
+
+// write writes a batch to a new file in dir. It must be called with the mutex held.
+func (s *Spool) write(dir string, records []string) error {
+	// Write batch to a temporary file then rename it so partial batches are never replayed
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), s.seq%1000000)
+	tmpPath := filepath.Join(dir, name+tmpExt)
+	err := ioutil.WriteFile(tmpPath, []byte(strings.Join(records, "\n")+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(dir, name+fileExt))
+}
+
+// Replay passes each stored batch to write, oldest first, removing it once written. Batches write
+// rejects with a RejectedError are moved aside and replaying carries on, so one bad batch can't
+// hold up the rest. Otherwise it stops at the first batch that fails to write, returning the
+// number of batches written and the error.
+func (s *Spool) Replay(write func(records []string) error) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired batches before replaying
+	err := s.prune()
+	if err != nil {
+		return 0, err
+	}
+	files, err := s.files()
+	if err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for _, file := range files {
+		path := filepath.Join(s.dir, file.name)
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return written, err
+		}
+		records := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+		err = write(records)
+		if IsRejected(err) {
+			if s.logger != nil {
+				s.logger.Printf("Moving aside spooled batch %s: %s\n", file.name, err)
+			}
+			err = os.Rename(path, filepath.Join(s.dir, rejectedDir, file.name))
+			if err != nil {
+				return written, err
+			}
+			continue
+		}
+		if err != nil {
+			return written, err
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// Len returns the number of stored batches.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files, err := s.files()
+	if err != nil {
+		return 0
+	}
+	return len(files)
+}
+
+// prune removes batches older than maxAge, then the oldest batches until the total size is
+// within maxSize. It must be called with the mutex held.
+func (s *Spool) prune() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	var totalSize int64
+	for _, file := range files {
+		totalSize += file.size
+	}
+
+	dropped := 0
+	for _, file := range files {
+		expired := s.maxAge > 0 && time.Since(file.created) > s.maxAge
+		oversize := s.maxSize > 0 && totalSize > s.maxSize
+		if !expired && !oversize {
+			break
+		}
+		err = os.Remove(filepath.Join(s.dir, file.name))
+		if err != nil {
+			return err
+		}
+		totalSize -= file.size
+		dropped++
+	}
+	if dropped > 0 && s.logger != nil {
+		s.logger.Printf("Dropped %d spooled batches exceeding the spool limits\n", dropped)
+	}
+
+	// Remove expired rejected batches
+	if s.maxAge <= 0 {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(s.dir, rejectedDir))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if time.Since(entry.ModTime()) > s.maxAge {
+			err = os.Remove(filepath.Join(s.dir, rejectedDir, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// files returns the stored batches, oldest first. It must be called with the mutex held.
+func (s *Spool) files() ([]batchFile, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []batchFile
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != fileExt {
+			continue
+		}
+		files = append(files, batchFile{name: entry.Name(), size: entry.Size(), created: entry.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+	return files, nil
+}
@@ -0,0 +1,190 @@
+package spool
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSpool(t *testing.T, maxSize int64, maxAge time.Duration) (*Spool, *bytes.Buffer) {
+	t.Helper()
+	var logs bytes.Buffer
+	s, err := New(t.TempDir(), maxSize, maxAge, log.New(&logs, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, &logs
+}
+
+func addBatches(t *testing.T, s *Spool, batches ...[]string) {
+	t.Helper()
+	for _, batch := range batches {
+		err := s.Add(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func rejectedFiles(t *testing.T, s *Spool) []string {
+	t.Helper()
+	entries, err := ioutil.ReadDir(filepath.Join(s.dir, rejectedDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, entry := range entries {
+		content, err := ioutil.ReadFile(filepath.Join(s.dir, rejectedDir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(content))
+	}
+	return contents
+}
+
+func TestSpoolReplaysInOrder(t *testing.T) {
+	s, _ := newTestSpool(t, 0, 0)
+	addBatches(t, s, []string{"a 1", "a 2"}, []string{"b 1"}, []string{"c 1"})
+
+	// A failed write stops replaying, keeping that batch and those after it
+	var replayed [][]string
+	writeErr := errors.New("connection refused")
+	written, err := s.Replay(func(records []string) error {
+		if len(replayed) == 1 {
+			return writeErr
+		}
+		replayed = append(replayed, records)
+		return nil
+	})
+	if written != 1 || err != writeErr {
+		t.Fatalf("replay = %d, %v, want 1, %v", written, err, writeErr)
+	}
+	if s.Len() != 2 {
+		t.Fatalf("spool has %d batches, want 2", s.Len())
+	}
+
+	// Replaying again carries on in order, removing the written batches
+	written, err = s.Replay(func(records []string) error {
+		replayed = append(replayed, records)
+		return nil
+	})
+	if written != 2 || err != nil {
+		t.Fatalf("replay = %d, %v, want 2, nil", written, err)
+	}
+	want := [][]string{{"a 1", "a 2"}, {"b 1"}, {"c 1"}}
+	if !reflect.DeepEqual(replayed, want) {
+		t.Errorf("replayed %v, want %v", replayed, want)
+	}
+	if s.Len() != 0 {
+		t.Errorf("spool has %d batches, want 0", s.Len())
+	}
+}
+
+func TestSpoolMovesRejectedBatchesAside(t *testing.T) {
+	s, logs := newTestSpool(t, 0, 0)
+	addBatches(t, s, []string{"a 1"}, []string{"poisoned"}, []string{"c 1"})
+
+	// A rejected batch doesn't hold up the ones after it
+	var replayed [][]string
+	written, err := s.Replay(func(records []string) error {
+		if records[0] == "poisoned" {
+			return &RejectedError{Err: errors.New("field type conflict")}
+		}
+		replayed = append(replayed, records)
+		return nil
+	})
+	if written != 2 || err != nil {
+		t.Fatalf("replay = %d, %v, want 2, nil", written, err)
+	}
+	want := [][]string{{"a 1"}, {"c 1"}}
+	if !reflect.DeepEqual(replayed, want) {
+		t.Errorf("replayed %v, want %v", replayed, want)
+	}
+	if s.Len() != 0 {
+		t.Errorf("spool has %d batches, want 0", s.Len())
+	}
+	if rejected := rejectedFiles(t, s); !reflect.DeepEqual(rejected, []string{"poisoned\n"}) {
+		t.Errorf("rejected batches = %q, want the poisoned batch", rejected)
+	}
+	if !strings.Contains(logs.String(), "field type conflict") {
+		t.Errorf("rejection not logged: %s", logs)
+	}
+
+	// Rejected batches aren't replayed again
+	written, err = s.Replay(func(records []string) error {
+		t.Errorf("replayed %v", records)
+		return nil
+	})
+	if written != 0 || err != nil {
+		t.Errorf("replay = %d, %v, want 0, nil", written, err)
+	}
+}
+
+func TestSpoolPrunesBySize(t *testing.T) {
+	// Each batch is 4 bytes, so only the newest two fit
+	s, logs := newTestSpool(t, 8, 0)
+	addBatches(t, s, []string{"a 1"}, []string{"b 1"}, []string{"c 1"})
+	var replayed []string
+	_, err := s.Replay(func(records []string) error {
+		replayed = append(replayed, records...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b 1", "c 1"}; !reflect.DeepEqual(replayed, want) {
+		t.Errorf("replayed %v, want %v", replayed, want)
+	}
+	if !strings.Contains(logs.String(), "Dropped 1 spooled batches") {
+		t.Errorf("dropped batches not logged: %s", logs)
+	}
+}
+
+func TestSpoolPrunesByAge(t *testing.T) {
+	s, _ := newTestSpool(t, 0, time.Hour)
+	addBatches(t, s, []string{"old"}, []string{"new"})
+	err := s.Reject([]string{"old rejected"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Age the first batch and the rejected batch past the limit
+	old := time.Now().Add(-2 * time.Hour)
+	files, err := s.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected, err := ioutil.ReadDir(filepath.Join(s.dir, rejectedDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{filepath.Join(s.dir, files[0].name), filepath.Join(s.dir, rejectedDir, rejected[0].Name())} {
+		err = os.Chtimes(path, old, old)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var replayed []string
+	_, err = s.Replay(func(records []string) error {
+		replayed = append(replayed, records...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"new"}; !reflect.DeepEqual(replayed, want) {
+		t.Errorf("replayed %v, want %v", replayed, want)
+	}
+	if rejected := rejectedFiles(t, s); len(rejected) != 0 {
+		t.Errorf("expired rejected batches kept: %q", rejected)
+	}
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"sort"
+	"time"
+)
+
+// Power returns live power for each commodity, aggregated over each step.
+func (s *Store) Power(query models.HistoryQuery) ([]models.HistorySeries, error) {
+	points, err := s.Query(models.MeasurementMeterData, map[string]string{"system": query.SystemID, "source": models.SourceLive}, query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+	step := int64(query.Step / time.Second)
+	if step <= 0 {
+		step = 1
+	}
+
+	// Aggregate each commodity over each step
+	type bucket struct {
+		value float64
+		count int
+	}
+	buckets := map[string]map[int64]*bucket{}
+	for _, point := range points {
+		value, ok := point.Fields["val"]
+		if !ok {
+			continue
+		}
+		commodity := point.Tags["type"]
+		if buckets[commodity] == nil {
+			buckets[commodity] = map[int64]*bucket{}
+		}
+		start := point.Timestamp - point.Timestamp%step
+		b, ok := buckets[commodity][start]
+		if !ok {
+			buckets[commodity][start] = &bucket{value: value, count: 1}
+			continue
+		}
+		switch query.Aggregate {
+		case models.AggregateMax:
+			if value > b.value {
+				b.value = value
+			}
+		case models.AggregateMin:
+			if value < b.value {
+				b.value = value
+			}
+		default:
+			b.value += value
+		}
+		b.count++
+	}
+
+	commodities := make([]string, 0, len(buckets))
+	for commodity := range buckets {
+		commodities = append(commodities, commodity)
+	}
+	sort.Strings(commodities)
+	var series []models.HistorySeries
+	for _, commodity := range commodities {
+		starts := make([]int64, 0, len(buckets[commodity]))
+		for start := range buckets[commodity] {
+			starts = append(starts, start)
+		}
+		sort.Slice(starts, func(i, j int) bool {
+			return starts[i] < starts[j]
+		})
+		result := models.HistorySeries{Commodity: commodity, Unit: "W", Points: []models.HistoryPoint{}}
+		for _, start := range starts {
+			b := buckets[commodity][start]
+			value := b.value
+			if query.Aggregate != models.AggregateMax && query.Aggregate != models.AggregateMin {
+				value /= float64(b.count)
+			}
+			result.Points = append(result.Points, models.HistoryPoint{Time: time.Unix(start, 0).UTC(), Value: value})
+		}
+		series = append(series, result)
+	}
+	return series, nil
+}
+
+// Energy returns the energy used by each commodity in each interval, worked out from the change in
+// the total consumption meter readings since the previous interval with readings.
+func (s *Store) Energy(query models.HistoryQuery) ([]models.HistorySeries, error) {
+	first := models.IntervalStart(query.From, query.Interval)
+	points, err := s.Query(models.MeasurementMeterData, map[string]string{"system": query.SystemID, "source": models.SourcePeriodic, "unit": s.schema.EnergyUnit()}, models.PreviousInterval(first, query.Interval), query.To)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the last reading of each commodity in each interval
+	last := map[string]map[int64]float64{}
+	for _, point := range points {
+		value, ok := point.Fields["val"]
+		if !ok {
+			continue
+		}
+		commodity := point.Tags["type"]
+		if last[commodity] == nil {
+			last[commodity] = map[int64]float64{}
+		}
+		last[commodity][models.IntervalStart(time.Unix(point.Timestamp, 0), query.Interval).Unix()] = value
+	}
+
+	commodities := make([]string, 0, len(last))
+	for commodity := range last {
+		commodities = append(commodities, commodity)
+	}
+	sort.Strings(commodities)
+	var series []models.HistorySeries
+	for _, commodity := range commodities {
+		starts := make([]int64, 0, len(last[commodity]))
+		for start := range last[commodity] {
+			starts = append(starts, start)
+		}
+		sort.Slice(starts, func(i, j int) bool {
+			return starts[i] < starts[j]
+		})
+		result := models.HistorySeries{Commodity: commodity, Unit: "kWh", Points: []models.HistoryPoint{}}
+		for i := 1; i < len(starts); i++ {
+			used := last[commodity][starts[i]] - last[commodity][starts[i-1]]
+			if starts[i] < first.Unix() || used < 0 {
+				continue
+			}
+			result.Points = append(result.Points, models.HistoryPoint{Time: time.Unix(starts[i], 0).UTC(), Value: used})
+		}
+		series = append(series, result)
+	}
+	return series, nil
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStoreHistory(t *testing.T) {
+	start := truncateDay(time.Now()).Add(-24 * time.Hour)
+	at := func(minutes int) int64 {
+		return start.Add(time.Duration(minutes) * time.Minute).Unix()
+	}
+	hours := func(n int) time.Time {
+		return start.Add(time.Duration(n) * time.Hour)
+	}
+
+	for _, schema := range []models.Schema{{Version: models.SchemaV1}, {Version: models.SchemaV2}} {
+		schema := schema
+		t.Run(schema.EnergyUnit(), func(t *testing.T) {
+			s, err := Open(t.TempDir(), Options{Retention: 30 * 24 * time.Hour, RawRetention: 7 * 24 * time.Hour, DownsampleInterval: time.Hour}, schema, log.New(ioutil.Discard, "", 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, readings := range []models.Readings{{
+				System: "system-1",
+				Source: models.SourceLive,
+				Power: []models.PowerReading{
+					{Commodity: "ELECTRICITY", Watts: 100, Timestamp: at(0)},
+					{Commodity: "ELECTRICITY", Watts: 300, Timestamp: at(30)},
+					{Commodity: "ELECTRICITY", Watts: 50, Timestamp: at(60)},
+				},
+			}, {
+				System: "system-1",
+				Source: models.SourcePeriodic,
+				Consumption: []models.ConsumptionReading{
+					{Commodity: "ELECTRICITY", Value: 100, Unit: "kWh", Timestamp: at(10)},
+					{Commodity: "ELECTRICITY", Value: 101.5, Unit: "kWh", Timestamp: at(70)},
+					{Commodity: "ELECTRICITY", Value: 104, Unit: "kWh", Timestamp: at(130)},
+				},
+			}, {
+				System: "system-2",
+				Source: models.SourceLive,
+				Power:  []models.PowerReading{{Commodity: "ELECTRICITY", Watts: 900, Timestamp: at(0)}},
+			}} {
+				err = s.Write(readings)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Power is aggregated over each step
+			series, err := s.Power(models.HistoryQuery{SystemID: "system-1", From: start, To: hours(3), Step: time.Hour, Aggregate: models.AggregateMean})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []models.HistorySeries{{Commodity: "ELECTRICITY", Unit: "W", Points: []models.HistoryPoint{
+				{Time: hours(0), Value: 200},
+				{Time: hours(1), Value: 50},
+			}}}
+			if !reflect.DeepEqual(series, want) {
+				t.Errorf("power = %+v, want %+v", series, want)
+			}
+			series, err = s.Power(models.HistoryQuery{SystemID: "system-1", From: start, To: hours(1), Step: time.Hour, Aggregate: models.AggregateMax})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(series) != 1 || len(series[0].Points) != 1 || series[0].Points[0].Value != 300 {
+				t.Errorf("max power = %+v, want 300", series)
+			}
+
+			// Energy is the change in the total since the previous interval, starting from the first
+			// interval queried
+			series, err = s.Energy(models.HistoryQuery{SystemID: "system-1", From: hours(1), To: hours(3), Interval: models.IntervalHour})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want = []models.HistorySeries{{Commodity: "ELECTRICITY", Unit: "kWh", Points: []models.HistoryPoint{
+				{Time: hours(1), Value: 1.5},
+				{Time: hours(2), Value: 2.5},
+			}}}
+			if !reflect.DeepEqual(series, want) {
+				t.Errorf("energy = %+v, want %+v", series, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// dayFormat is the format of the day each file holds points for
+	dayFormat = "2006-01-02"
+	// fileExt is the extension of day files
+	fileExt = ".jsonl"
+	// rawDir and downsampledDir are the directories raw and downsampled day files are kept in
+	rawDir         = "raw"
+	downsampledDir = "downsampled"
+	// maintenanceInterval is how often old days are downsampled and expired
+	maintenanceInterval = time.Hour
+)
+
+// record is a point as stored on disk.
+type record struct {
+	Measurement string             `json:"m"`
+	Tags        map[string]string  `json:"t"`
+	Fields      map[string]float64 `json:"f"`
+	Timestamp   int64              `json:"ts"`
+	// Count is the number of points a downsampled record was aggregated from
+	Count int `json:"n,omitempty"`
+}
+
+// Options configures how long points are kept.
+type Options struct {
+	// Retention is how long points are kept for
+	Retention time.Duration
+	// RawRetention is how long points are kept at full resolution before being downsampled
+	RawRetention time.Duration
+	// DownsampleInterval is the interval points are downsampled to
+	DownsampleInterval time.Duration
+}
+
+// Store is an embedded time series store for meter data, keeping a file of points for each day.
+// Recent days are kept at full resolution, then downsampled to save space until they expire. It
+// is safe for concurrent use.
+type Store struct {
+	dir          string
+	options      Options
+	schema       models.Schema
+	logger       *log.Logger
+	mu           sync.RWMutex
+	lastMaintain time.Time
+}
+
+// Open returns a Store keeping its files in dir, creating it if needed, and downsamples and expires
+// any old days. Points are written with schema.
+func Open(dir string, options Options, schema models.Schema, logger *log.Logger) (*Store, error) {
+	for _, subDir := range []string{rawDir, downsampledDir} {
+		err := os.MkdirAll(filepath.Join(dir, subDir), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s := &Store{dir: dir, options: options, schema: schema, logger: logger}
+	err := s.Maintain()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Name returns the name of the sink.
+func (s *Store) Name() string {
+	return "store"
+}
+
+// Write stores readings, downsampling and expiring old days if due.
+func (s *Store) Write(readings models.Readings) error {
+	err := s.WritePoints(readings.Points(s.schema))
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	due := time.Since(s.lastMaintain) >= maintenanceInterval
+	s.mu.RUnlock()
+	if due {
+		return s.Maintain()
+	}
+	return nil
+}
+
+// WritePoints appends points to the files of the days they are for.
+func (s *Store) WritePoints(points []models.Point) error {
+	// Group points by day
+	byDay := map[string][]byte{}
+	for _, point := range points {
+		tags := map[string]string{}
+		for key, value := range point.Tags {
+			if value != "" {
+				tags[key] = value
+			}
+		}
+		line, err := json.Marshal(record{Measurement: point.Measurement, Tags: tags, Fields: point.Fields, Timestamp: point.Timestamp})
+		if err != nil {
+			return err
+		}
+		day := time.Unix(point.Timestamp, 0).UTC().Format(dayFormat)
+		byDay[day] = append(append(byDay[day], line...), '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for day, lines := range byDay {
+		file, err := os.OpenFile(s.path(rawDir, day), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(lines)
+		closeErr := file.Close()
+		if err != nil {
+			return err
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+	}
+	return nil
+}
+
+// Query returns the points of a measurement from from up to to whose tags include all of tags,
+// oldest first. Days that have been downsampled return the downsampled points, along with any
+// points written since. Points written more than once are only returned once.
+func (s *Store) Query(measurement string, tags map[string]string, from, to time.Time) ([]models.Point, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var points []models.Point
+	seen := map[string]int{}
+	for day := truncateDay(from); day.Before(to); day = day.Add(24 * time.Hour) {
+		// Read the downsampled points of the day, then any raw points not yet downsampled
+		records, err := readRecords(s.path(downsampledDir, day.Format(dayFormat)))
+		if err != nil {
+			return nil, err
+		}
+		raw, err := readRecords(s.path(rawDir, day.Format(dayFormat)))
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, raw...)
+
+		for _, r := range records {
+			if r.Measurement != measurement || r.Timestamp < from.Unix() || r.Timestamp >= to.Unix() || !matchTags(r.Tags, tags) {
+				continue
+			}
+			point := models.Point{Measurement: r.Measurement, Tags: r.Tags, Fields: r.Fields, Timestamp: r.Timestamp}
+			key := seriesKey(r) + "@" + time.Unix(r.Timestamp, 0).Format(time.RFC3339)
+			if i, ok := seen[key]; ok {
+				points[i] = point
+				continue
+			}
+			seen[key] = len(points)
+			points = append(points, point)
+		}
+	}
+
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Timestamp < points[j].Timestamp
+	})
+	return points, nil
+}
+
+// Maintain downsamples days older than the raw retention and removes days older than the
+// retention.
+func (s *Store) Maintain() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastMaintain = time.Now()
+	today := truncateDay(time.Now())
+
+	// Downsample raw days, and remove any that have expired
+	days, err := s.days(rawDir)
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		if today.Sub(day) < s.options.RawRetention || !day.Before(today) {
+			continue
+		}
+		if today.Sub(day) < s.options.Retention {
+			err = s.downsample(day)
+			if err != nil {
+				return err
+			}
+		}
+		err = os.Remove(s.path(rawDir, day.Format(dayFormat)))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Remove expired downsampled days
+	days, err = s.days(downsampledDir)
+	if err != nil {
+		return err
+	}
+	expired := 0
+	for _, day := range days {
+		if today.Sub(day) < s.options.Retention {
+			continue
+		}
+		err = os.Remove(s.path(downsampledDir, day.Format(dayFormat)))
+		if err != nil {
+			return err
+		}
+		expired++
+	}
+	if expired > 0 && s.logger != nil {
+		s.logger.Printf("Removed %d expired days from the store\n", expired)
+	}
+	return nil
+}
+
+// Close does nothing as files are only open while being written.
+func (s *Store) Close() {}
+
+// downsample merges the points of a raw day into its downsampled points, so points written late
+// for a day already downsampled are added to it. Live power is averaged over each interval and
+// everything else, being totals, keeps the last value in each interval. It must be called with the
+// mutex held.
+func (s *Store) downsample(day time.Time) error {
+	path := s.path(downsampledDir, day.Format(dayFormat))
+	records, err := readRecords(path)
+	if err != nil {
+		return err
+	}
+	raw, err := readRecords(s.path(rawDir, day.Format(dayFormat)))
+	if err != nil {
+		return err
+	}
+	records = append(records, raw...)
+	interval := int64(s.options.DownsampleInterval / time.Second)
+	if interval <= 0 {
+		interval = 1
+	}
+
+	// Aggregate each series over each interval
+	type bucket struct {
+		record record
+		sums   map[string]float64
+		count  int
+		last   int64
+	}
+	buckets := map[string]*bucket{}
+	var keys []string
+	for _, r := range records {
+		start := r.Timestamp - r.Timestamp%interval
+		key := seriesKey(r) + "@" + time.Unix(start, 0).Format(time.RFC3339)
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{record: record{Measurement: r.Measurement, Tags: r.Tags, Fields: map[string]float64{}, Timestamp: start}, sums: map[string]float64{}}
+			buckets[key] = b
+			keys = append(keys, key)
+		}
+		// Downsampled records count as the points they were aggregated from
+		count := r.Count
+		if count <= 0 {
+			count = 1
+		}
+		b.count += count
+		for field, value := range r.Fields {
+			b.sums[field] += value * float64(count)
+			if r.Timestamp >= b.last {
+				b.record.Fields[field] = value
+			}
+		}
+		if r.Timestamp >= b.last {
+			b.last = r.Timestamp
+		}
+	}
+
+	// Write downsampled points to a temporary file then rename it so a partial day is never read
+	var sb strings.Builder
+	for _, key := range keys {
+		b := buckets[key]
+		if b.record.Tags["source"] == models.SourceLive {
+			for field, sum := range b.sums {
+				b.record.Fields[field] = sum / float64(b.count)
+			}
+		}
+		b.record.Count = b.count
+		line, err := json.Marshal(b.record)
+		if err != nil {
+			return err
+		}
+		sb.Write(line)
+		sb.WriteByte('\n')
+	}
+	err = ioutil.WriteFile(path+".tmp", []byte(sb.String()), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// days returns the days there are files for in a directory, oldest first.
+func (s *Store) days(subDir string) ([]time.Time, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(s.dir, subDir))
+	if err != nil {
+		return nil, err
+	}
+	var days []time.Time
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != fileExt {
+			continue
+		}
+		day, err := time.ParseInLocation(dayFormat, strings.TrimSuffix(entry.Name(), fileExt), time.UTC)
+		if err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Before(days[j])
+	})
+	return days, nil
+}
+
+func (s *Store) path(subDir, day string) string {
+	return filepath.Join(s.dir, subDir, day+fileExt)
+}
+
+// readRecords reads the records in a day file, returning none if it doesn't exist. Lines that
+// can't be read, such as one left partly written by a crash, are skipped.
+func readRecords(path string) ([]record, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r record
+		if json.Unmarshal(scanner.Bytes(), &r) == nil {
+			records = append(records, r)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// seriesKey identifies the series a record belongs to.
+func seriesKey(r record) string {
+	keys := make([]string, 0, len(r.Tags))
+	for key := range r.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString(r.Measurement)
+	for _, key := range keys {
+		sb.WriteString("," + key + "=" + r.Tags[key])
+	}
+	return sb.String()
+}
+
+// matchTags reports whether tags include all of want.
+func matchTags(tags, want map[string]string) bool {
+	for key, value := range want {
+		if tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, options Options) *Store {
+	t.Helper()
+	s, err := Open(t.TempDir(), options, models.Schema{Version: models.SchemaV2}, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// livePoint returns a live power point for a system.
+func livePoint(system string, watts float64, at time.Time) models.Point {
+	return models.Point{
+		Measurement: models.MeasurementMeterData,
+		Tags:        map[string]string{"system": system, "source": models.SourceLive, "type": "ELECTRICITY", "unit": "W"},
+		Fields:      map[string]float64{"val": watts},
+		Timestamp:   at.Unix(),
+	}
+}
+
+// values returns the val field of points.
+func values(points []models.Point) []float64 {
+	var vals []float64
+	for _, point := range points {
+		vals = append(vals, point.Fields["val"])
+	}
+	return vals
+}
+
+func TestStoreWriteQuery(t *testing.T) {
+	s := newTestStore(t, Options{Retention: 30 * 24 * time.Hour, RawRetention: 7 * 24 * time.Hour, DownsampleInterval: time.Hour})
+	start := truncateDay(time.Now()).Add(-24 * time.Hour)
+	err := s.WritePoints([]models.Point{
+		livePoint("system-1", 300, start.Add(23*time.Hour)),
+		livePoint("system-1", 100, start.Add(time.Minute)),
+		livePoint("system-2", 500, start.Add(time.Minute)),
+		// Written again with a new value
+		livePoint("system-1", 150, start.Add(time.Minute)),
+		// The next day
+		livePoint("system-1", 200, start.Add(25*time.Hour)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Points are filtered by tags and time, returned oldest first and only once
+	points, err := s.Query(models.MeasurementMeterData, map[string]string{"system": "system-1"}, start, start.Add(26*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := values(points), []float64{150, 300, 200}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+	points, err = s.Query(models.MeasurementMeterData, map[string]string{"system": "system-1"}, start.Add(time.Hour), start.Add(25*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := values(points), []float64{300}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values from an hour in = %v, want %v", got, want)
+	}
+}
+
+func TestStoreDownsample(t *testing.T) {
+	s := newTestStore(t, Options{Retention: 30 * 24 * time.Hour, RawRetention: 2 * 24 * time.Hour, DownsampleInterval: time.Hour})
+	day := truncateDay(time.Now()).Add(-5 * 24 * time.Hour)
+	total := func(kwh float64, at time.Time) models.Point {
+		return models.Point{
+			Measurement: models.MeasurementMeterData,
+			Tags:        map[string]string{"system": "system-1", "source": models.SourcePeriodic, "type": "ELECTRICITY", "unit": "kWh"},
+			Fields:      map[string]float64{"val": kwh},
+			Timestamp:   at.Unix(),
+		}
+	}
+	err := s.WritePoints([]models.Point{
+		livePoint("system-1", 100, day.Add(10*time.Minute)),
+		livePoint("system-1", 200, day.Add(20*time.Minute)),
+		total(10, day.Add(10*time.Minute)),
+		total(12, day.Add(40*time.Minute)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Live power is averaged and totals keep the last value
+	err = s.Maintain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s.path(rawDir, day.Format(dayFormat))); !os.IsNotExist(err) {
+		t.Error("raw day kept after downsampling")
+	}
+	query := func(source string) []models.Point {
+		t.Helper()
+		points, err := s.Query(models.MeasurementMeterData, map[string]string{"source": source}, day, day.Add(24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return points
+	}
+	live := query(models.SourceLive)
+	if got, want := values(live), []float64{150}; !reflect.DeepEqual(got, want) || live[0].Timestamp != day.Unix() {
+		t.Errorf("downsampled live = %v at %d, want %v at %d", got, live[0].Timestamp, want, day.Unix())
+	}
+	if got, want := values(query(models.SourcePeriodic)), []float64{12}; !reflect.DeepEqual(got, want) {
+		t.Errorf("downsampled totals = %v, want %v", got, want)
+	}
+
+	// Points written late are returned along with the downsampled points, then merged into them
+	err = s.WritePoints([]models.Point{
+		livePoint("system-1", 450, day.Add(30*time.Minute)),
+		livePoint("system-1", 80, day.Add(90*time.Minute)),
+		total(13, day.Add(50*time.Minute)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := values(query(models.SourceLive)), []float64{150, 450, 80}; !reflect.DeepEqual(got, want) {
+		t.Errorf("live before merging = %v, want %v", got, want)
+	}
+	err = s.Maintain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := values(query(models.SourceLive)), []float64{250, 80}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged live = %v, want %v", got, want)
+	}
+	if got, want := values(query(models.SourcePeriodic)), []float64{13}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged totals = %v, want %v", got, want)
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	s := newTestStore(t, Options{Retention: 10 * 24 * time.Hour, RawRetention: 2 * 24 * time.Hour, DownsampleInterval: time.Hour})
+	today := truncateDay(time.Now())
+	expired := today.Add(-20 * 24 * time.Hour)
+	downsampled := today.Add(-5 * 24 * time.Hour)
+	err := s.WritePoints([]models.Point{
+		livePoint("system-1", 100, expired),
+		livePoint("system-1", 200, downsampled),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Maintain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired raw days are removed without being downsampled
+	points, err := s.Query(models.MeasurementMeterData, nil, expired, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := values(points), []float64{200}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+
+	// Downsampled days are removed once they expire
+	s.options.Retention = 3 * 24 * time.Hour
+	err = s.Maintain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	days, err := s.days(downsampledDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 0 {
+		t.Errorf("downsampled days = %v, want none", days)
+	}
+}
@@ -0,0 +1,302 @@
+package stream
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// ChannelLive, ChannelPeriodic, ChannelCosts and ChannelAlerts are the channels WebSocket
+	// clients can subscribe to
+	ChannelLive     = "live"
+	ChannelPeriodic = "periodic"
+	ChannelCosts    = "costs"
+	ChannelAlerts   = "alerts"
+
+	// sendQueueSize is how many messages can be queued for a client before backpressure applies
+	sendQueueSize = 64
+	// writeWait is the longest writing a single message is allowed to take
+	writeWait = 10 * time.Second
+	// pongWait is how long to wait for a pong before the connection is treated as dead
+	pongWait = 60 * time.Second
+	// pingPeriod is how often pings are sent, which must be less than pongWait
+	pingPeriod = 30 * time.Second
+	// maxMessageSize is the largest message accepted from a client
+	maxMessageSize = 4096
+)
+
+// Message is a message sent to WebSocket clients. Data messages are sent on a channel with data
+// for a system, and control messages confirm subscriptions or report errors.
+type Message struct {
+	Type     string      `json:"type"`
+	Channel  string      `json:"channel,omitempty"`
+	System   string      `json:"system,omitempty"`
+	Channels []string    `json:"channels,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// request is a message received from a WebSocket client.
+type request struct {
+	Type     string   `json:"type"`
+	Channels []string `json:"channels"`
+	System   string   `json:"system"`
+}
+
+// client is a WebSocket connection and the channels it's subscribed to.
+type client struct {
+	conn      *websocket.Conn
+	send      chan Message
+	channels  map[string]bool
+	system    string
+	closeCode int
+	closeText string
+}
+
+// Hub is a sink that serves WebSocket connections, pushing meter data and alerts on the channels
+// each client has subscribed to. Clients that fall behind have live messages dropped, as the
+// next one supersedes them, and are disconnected if any other message can't be queued. It is safe
+// for concurrent use.
+type Hub struct {
+	logger   *log.Logger
+	upgrader websocket.Upgrader
+	mu       sync.Mutex
+	clients  map[*client]bool
+	closed   bool
+}
+
+// NewHub returns a Hub with no clients.
+func NewHub(logger *log.Logger) *Hub {
+	h := &Hub{logger: logger, clients: map[*client]bool{}}
+	h.upgrader.Error = func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		response, _ := json.Marshal(models.NewErrorResponse(status, reason.Error()))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write(response)
+	}
+	return h
+}
+
+// Name returns the name of the sink.
+func (h *Hub) Name() string {
+	return "websocket"
+}
+
+// Write sends readings to the clients subscribed to their channel.
+func (h *Hub) Write(readings models.Readings) error {
+	switch readings.Source {
+	case models.SourceLive:
+		if len(readings.Power) > 0 {
+			h.publish(Message{Type: "message", Channel: ChannelLive, System: readings.System, Data: readings.LiveUsage()})
+		}
+	case models.SourcePeriodic:
+		if len(readings.Consumption) > 0 {
+			h.publish(Message{Type: "message", Channel: ChannelPeriodic, System: readings.System, Data: readings.PeriodicUsage()})
+		}
+		if len(readings.Costs) > 0 {
+			h.publish(Message{Type: "message", Channel: ChannelCosts, System: readings.System, Data: readings.Costs})
+		}
+	}
+	return nil
+}
+
+// Alert sends an alert to the clients subscribed to alerts. It does nothing if h is nil.
+func (h *Hub) Alert(alert models.Alert) {
+	if h == nil {
+		return
+	}
+	if alert.Time == 0 {
+		alert.Time = time.Now().Unix()
+	}
+	h.publish(Message{Type: "message", Channel: ChannelAlerts, System: alert.System, Data: alert})
+}
+
+// Close disconnects every client.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for c := range h.clients {
+		h.disconnect(c, websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and serves it until it's closed.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded with an error
+		return
+	}
+	c := &client{conn: conn, send: make(chan Message, sendQueueSize), channels: map[string]bool{}}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		conn.Close()
+		return
+	}
+	h.clients[c] = true
+	h.mu.Unlock()
+
+	go h.writeMessages(c)
+	h.readRequests(c)
+}
+
+// publish queues a message for every client subscribed to its channel and system.
+func (h *Hub) publish(message Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		if !c.channels[message.Channel] || (c.system != "" && message.System != "" && c.system != message.System) {
+			continue
+		}
+		h.queue(c, message)
+	}
+}
+
+// queue queues a message for a client, applying backpressure if its queue is full. It must be
+// called with the mutex held.
+func (h *Hub) queue(c *client, message Message) {
+	select {
+	case c.send <- message:
+	default:
+		if message.Channel == ChannelLive {
+			return
+		}
+		h.logger.Printf("Disconnecting WebSocket client %s as it has fallen behind\n", c.conn.RemoteAddr())
+		h.disconnect(c, websocket.CloseTryAgainLater, "client too slow")
+	}
+}
+
+// disconnect removes a client, closing its queue so its writer closes the connection with code
+// and text. It must be called with the mutex held.
+func (h *Hub) disconnect(c *client, code int, text string) {
+	if !h.clients[c] {
+		return
+	}
+	delete(h.clients, c)
+	c.closeCode = code
+	c.closeText = text
+	close(c.send)
+}
+
+// readRequests handles subscription requests from a client until its connection is closed.
+func (h *Hub) readRequests(c *client) {
+	defer func() {
+		h.mu.Lock()
+		h.disconnect(c, websocket.CloseNormalClosure, "")
+		h.mu.Unlock()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	err := c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	if err != nil {
+		return
+	}
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		var req request
+		err := c.conn.ReadJSON(&req)
+		if err != nil {
+			// Invalid JSON leaves the connection usable, anything else means it's closed
+			switch err.(type) {
+			case *json.SyntaxError, *json.UnmarshalTypeError:
+				h.reply(c, Message{Type: "error", Error: "Invalid request"})
+				continue
+			}
+			return
+		}
+
+		// Validate channels
+		invalid := ""
+		for _, channel := range req.Channels {
+			if channel != ChannelLive && channel != ChannelPeriodic && channel != ChannelCosts && channel != ChannelAlerts {
+				invalid = channel
+			}
+		}
+		if invalid != "" {
+			h.reply(c, Message{Type: "error", Error: "Invalid channel " + invalid})
+			continue
+		}
+
+		// Update subscriptions
+		h.mu.Lock()
+		switch req.Type {
+		case "subscribe":
+			for _, channel := range req.Channels {
+				c.channels[channel] = true
+			}
+			c.system = req.System
+		case "unsubscribe":
+			for _, channel := range req.Channels {
+				delete(c.channels, channel)
+			}
+		default:
+			h.mu.Unlock()
+			h.reply(c, Message{Type: "error", Error: "Invalid request type, expected subscribe or unsubscribe"})
+			continue
+		}
+		channels := make([]string, 0, len(c.channels))
+		for _, channel := range []string{ChannelLive, ChannelPeriodic, ChannelCosts, ChannelAlerts} {
+			if c.channels[channel] {
+				channels = append(channels, channel)
+			}
+		}
+		if h.clients[c] {
+			h.queue(c, Message{Type: "subscribed", System: c.system, Channels: channels})
+		}
+		h.mu.Unlock()
+	}
+}
+
+// reply queues a control message for a client.
+func (h *Hub) reply(c *client, message Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[c] {
+		h.queue(c, message)
+	}
+}
+
+// writeMessages writes queued messages and pings to a client until its queue is closed or a write
+// fails.
+func (h *Hub) writeMessages(c *client) {
+	ping := time.NewTicker(pingPeriod)
+	defer func() {
+		ping.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				// Disconnected, so tell the client why
+				_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeText), time.Now().Add(writeWait))
+				return
+			}
+			err := c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err != nil {
+				return
+			}
+			err = c.conn.WriteJSON(message)
+			if err != nil {
+				return
+			}
+		case <-ping.C:
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package stream
+
+import (
+	"github.com/olivercullimore/geo-energy-data/server/models"
+	"sync"
+)
+
+const (
+	// historySize is how many events are kept for subscribers resuming a stream
+	historySize = 100
+	// subscriberBuffer is how many events can be queued for a subscriber before it's dropped
+	subscriberBuffer = 16
+)
+
+// Broker is a sink that fans live usage out to any number of subscribers, keeping recent events so
+// subscribers can resume after reconnecting. It is safe for concurrent use.
+type Broker struct {
+	mu          sync.Mutex
+	lastID      uint64
+	history     []models.LiveUsageEvent
+	subscribers map[chan models.LiveUsageEvent]bool
+	closed      bool
+}
+
+// NewBroker returns a Broker with no subscribers.
+func NewBroker() *Broker {
+	return &Broker{subscribers: map[chan models.LiveUsageEvent]bool{}}
+}
+
+// Name returns the name of the sink.
+func (b *Broker) Name() string {
+	return "stream"
+}
+
+// Write sends the live usage of live readings to every subscriber. Subscribers that have fallen
+// behind are dropped so they can't hold up the others, and can resume from their last event.
+func (b *Broker) Write(readings models.Readings) error {
+	if readings.Source != models.SourceLive || len(readings.Power) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+
+	// IDs are based on the fetch time so they keep increasing after a restart
+	id := uint64(readings.FetchedAt.UnixNano() / 1e6)
+	if id <= b.lastID {
+		id = b.lastID + 1
+	}
+	b.lastID = id
+	event := models.LiveUsageEvent{ID: id, System: readings.System, LiveUsage: readings.LiveUsage()}
+	b.history = append(b.history, event)
+	if len(b.history) > historySize {
+		b.history = b.history[len(b.history)-historySize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+// Subscribe returns the kept events after lastID and a channel of new events. The channel is
+// closed if the subscriber falls behind or the broker is closed.
+func (b *Broker) Subscribe(lastID uint64) ([]models.LiveUsageEvent, <-chan models.LiveUsageEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var missed []models.LiveUsageEvent
+	if lastID > 0 {
+		for _, event := range b.history {
+			if event.ID > lastID {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	ch := make(chan models.LiveUsageEvent, subscriberBuffer)
+	if b.closed {
+		close(ch)
+		return missed, ch, func() {}
+	}
+	b.subscribers[ch] = true
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.subscribers[ch] {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return missed, ch, unsubscribe
+}
+
+// Close ends every subscription.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,152 @@
+package tlsreload
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// checkInterval is how often the files are checked for changes, on the next handshake after it
+	checkInterval = 10 * time.Second
+)
+
+// Reloader serves a certificate and key, and optionally a CA bundle to verify client certificates
+// with, reloading them when the files change so renewed certificates are used without a restart.
+// It is safe for concurrent use.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	logger       *log.Logger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+// New returns a Reloader for the certificate and key files, and the client CA file if it's set,
+// loading them now.
+func New(certFile, keyFile, clientCAFile string, logger *log.Logger) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile, logger: logger}
+	err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Config returns a TLS config serving the current certificate, requiring clients to present a
+// certificate signed by the client CAs if clientAuth is tls.RequireAndVerifyClientCert, or
+// verifying it only if given if clientAuth is tls.VerifyClientCertIfGiven.
+func (r *Reloader) Config(clientAuth tls.ClientAuthType) *tls.Config {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: r.GetCertificate,
+	}
+	if r.clientCAFile != "" {
+		config.ClientAuth = clientAuth
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c := config.Clone()
+			c.GetConfigForClient = nil
+			c.ClientCAs = r.ClientCAs()
+			return c, nil
+		}
+	}
+	return config
+}
+
+// GetCertificate returns the current certificate, for use as the GetCertificate of a TLS config.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.check()
+	return r.cert, nil
+}
+
+// ClientCAs returns the current pool of client CAs.
+func (r *Reloader) ClientCAs() *x509.CertPool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.check()
+	return r.clientCAs
+}
+
+// check reloads the files if they've changed since they were loaded, keeping the current ones if
+// they can't be loaded. It must be called with the mutex held.
+func (r *Reloader) check() {
+	if time.Since(r.lastCheck) < checkInterval {
+		return
+	}
+	r.lastCheck = time.Now()
+	modTimes, err := r.stat()
+	if err != nil {
+		r.logger.Printf("Unable to check TLS files for changes: %s\n", err)
+		return
+	}
+	for i := range modTimes {
+		if !modTimes[i].Equal(r.modTimes[i]) {
+			err = r.load()
+			if err != nil {
+				r.logger.Printf("Unable to reload TLS files, keeping current certificate: %s\n", err)
+			} else {
+				r.logger.Println("Reloaded TLS files")
+			}
+			return
+		}
+	}
+}
+
+// load loads the files. It must be called with the mutex held, or before the Reloader is shared.
+func (r *Reloader) load() error {
+	modTimes, err := r.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load TLS certificate: %w", err)
+	}
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("unable to load TLS client CA: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return errors.New("unable to load TLS client CA: no certificates found")
+		}
+	}
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	r.lastCheck = time.Now()
+	return nil
+}
+
+// stat returns the modification times of the files.
+func (r *Reloader) stat() ([]time.Time, error) {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	modTimes := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}